@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one line of the audit log. Before and After are content
+// hashes of the file an action changed, where that applies.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Type   string    `json:"type,omitempty"`
+	Slug   string    `json:"slug,omitempty"`
+	Before string    `json:"before,omitempty"`
+	After  string    `json:"after,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Log appends entries to a JSON lines file. Entries are never rewritten
+// or removed by the CMS.
+type Log struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+var current *Log
+
+// Open opens (or creates) the log file at path for appending
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, file: f}, nil
+}
+
+// SetLog sets the log Record writes to
+func SetLog(l *Log) {
+	current = l
+}
+
+// Record appends e to the current log. If there is no log, or it can't
+// be written, the entry goes to the server log instead.
+func Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if current == nil {
+		logEntry(e)
+		return
+	}
+	if err := current.append(e); err != nil {
+		log.Printf("audit: failed to write %s entry: %v", e.Action, err)
+		logEntry(e)
+	}
+}
+
+// CurrentLog returns the log Record writes to
+func CurrentLog() *Log {
+	return current
+}
+
+func logEntry(e Entry) {
+	log.Printf("audit: %s user=%q ip=%s type=%s slug=%s %s", e.Action, e.User, e.IP, e.Type, e.Slug, e.Detail)
+}
+
+func (l *Log) append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(line)
+	return err
+}
+
+// RecordConfig records that the config file name was loaded with the
+// given hash. There is no config reload: the file is only read at
+// startup, so a change shows up at the next start, as a config_change
+// entry with the previous and new hashes written before the config_load.
+func RecordConfig(name, hash string) {
+	entry := Entry{Action: "config_load", After: hash, Detail: name}
+	if current != nil {
+		last, _, err := current.Query(Filter{Action: "config_load", Limit: 1})
+		if err != nil {
+			log.Printf("audit: failed to read the last config_load: %v", err)
+		}
+		if len(last) == 1 {
+			entry.Before = last[0].After
+		}
+	}
+	if entry.Before != "" && entry.Before != hash {
+		Record(Entry{Action: "config_change", Before: entry.Before, After: hash, Detail: name})
+	}
+	Record(entry)
+}
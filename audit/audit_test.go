@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(`{"time":"2026-05-01T09:00:00Z","action":"config_load","after":"aaaa","detail":"config.json"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLog(l)
+	t.Cleanup(func() { SetLog(nil) })
+
+	RecordConfig("config.json", "aaaa") // unchanged
+	RecordConfig("config.json", "bbbb") // changed
+
+	entries, _, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Action: "config_load", Before: "aaaa", After: "bbbb"},
+		{Action: "config_change", Before: "aaaa", After: "bbbb"},
+		{Action: "config_load", Before: "aaaa", After: "aaaa"},
+		{Action: "config_load", After: "aaaa"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Action != want[i].Action || e.Before != want[i].Before || e.After != want[i].After || e.Detail != "config.json" {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"slices"
+	"time"
+)
+
+// Filter selects audit entries. Zero fields match everything.
+type Filter struct {
+	User   string
+	Action string
+	From   time.Time // inclusive
+	To     time.Time // exclusive
+	Limit  int
+}
+
+// Match reports whether e passes the filter
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.User != "" && e.User != f.User:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.Time.Before(f.To):
+		return false
+	}
+	return true
+}
+
+// Query returns matching entries, newest first, and every action name
+// seen in the log (for filter menus)
+func (l *Log) Query(f Filter) ([]Entry, []string, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // a torn last line from a crash shouldn't hide the rest
+		}
+		seen[e.Action] = true
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	slices.Reverse(entries)
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+
+	actions := make([]string, 0, len(seen))
+	for a := range seen {
+		actions = append(actions, a)
+	}
+	slices.Sort(actions)
+	return entries, actions, nil
+}
@@ -0,0 +1,99 @@
+// Command assets lists images no item uses and image references that
+// point at missing files, the same report as the /media/check page. With
+// -trash it also moves the unused images to the trash. It exits with
+// status 1 when anything is left to look at, so it can run in CI.
+//
+//	go run ./cmd/assets
+//	go run ./cmd/assets -trash
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cms/audit"
+	"cms/config"
+	"cms/index"
+	"cms/media"
+	"cms/storage"
+)
+
+func main() {
+	configPath := flag.String("config", "config.json", "CMS config file")
+	trash := flag.Bool("trash", false, "move unused images to the trash")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	config.LoadConfig(*configPath)
+	cfg := config.AppConfig
+	media.SetPublicDir(cfg.PublicDir)
+
+	if err := config.SetupStorage(); err != nil {
+		log.Fatalf("Failed to open git storage: %v", err)
+	}
+
+	contentIndex := index.New(cfg.ContentDir)
+	if err := contentIndex.Reindex(); err != nil {
+		log.Fatalf("Failed to read %s: %v", cfg.ContentDir, err)
+	}
+
+	report := media.CheckAssets(config.ImageDirs(), contentIndex.All())
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReport(report)
+	}
+
+	if *trash && len(report.Unchecked) > 0 {
+		log.Fatalf("Not moving anything to the trash: set publicDir so every images folder is inside it")
+	}
+	if *trash && len(report.Orphans) > 0 {
+		// A failed run is neither committed nor logged as a trashing; what
+		// it did move stays in the trash folder for a look by hand
+		changed, err := media.Trash(report.Orphans, cfg.TrashDir, time.Now())
+		if err != nil {
+			log.Fatalf("Failed to move unused images to the trash (%d files changed): %v", len(changed), err)
+		}
+		if err := storage.Commit(fmt.Sprintf("Move %d unused images to the trash", len(report.Orphans)), "", changed...); err != nil {
+			log.Printf("Failed to commit trashed images: %v", err)
+		}
+		if auditLog, err := audit.Open(cfg.AuditFile); err == nil {
+			audit.SetLog(auditLog)
+			audit.Record(audit.Entry{
+				Action: "media_trash",
+				Detail: fmt.Sprintf("%d unused images from the command line", len(report.Orphans)),
+			})
+		} else {
+			log.Printf("Failed to open audit log: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Moved %d unused images to %s\n", len(report.Orphans), cfg.TrashDir)
+		report.Orphans = nil
+	}
+
+	if !report.Clean() {
+		os.Exit(1)
+	}
+}
+
+func printReport(report media.Report) {
+	fmt.Printf("Unused images (%d):\n", len(report.Orphans))
+	for _, a := range report.Orphans {
+		fmt.Printf("  %s\t%s\t%s\n", a.Path, media.FormatSize(a.Size), a.ModTime.Format("2006-01-02"))
+	}
+	fmt.Printf("Missing images (%d):\n", len(report.Missing))
+	for _, m := range report.Missing {
+		fmt.Printf("  %s\tused by %s\n", m.URL, m.Slug)
+	}
+	if len(report.Unchecked) > 0 {
+		fmt.Printf("Not checked, outside publicDir %s (%d):\n", config.AppConfig.PublicDir, len(report.Unchecked))
+		for _, dir := range report.Unchecked {
+			fmt.Printf("  %s\n", dir)
+		}
+	}
+}
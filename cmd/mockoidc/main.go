@@ -0,0 +1,86 @@
+// Command mockoidc is a throwaway OpenID Connect issuer for trying the
+// CMS single sign-on locally. It signs ID tokens for whatever username and
+// groups you type in; never expose it anywhere.
+//
+//	go run ./cmd/mockoidc -addr localhost:9000
+package main
+
+import (
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cms/oidc/oidctest"
+)
+
+var issuer *oidctest.Issuer
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<body>
+  <h1>Mock OIDC issuer</h1>
+  <form method="POST">
+    {{ range $k, $v := .Params }}<input type="hidden" name="{{ $k }}" value="{{ index $v 0 }}" />{{ end }}
+    <label>Username <input name="username" value="sso-user" /></label><br/>
+    <label>Groups (comma separated) <input name="groups" value="cms-editors" /></label><br/>
+    <button type="submit">Log in</button>
+  </form>
+</body>
+</html>`))
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "listen address")
+	flag.Parse()
+
+	var err error
+	if issuer, err = oidctest.NewIssuer(); err != nil {
+		log.Fatal(err)
+	}
+	issuer.URL = "http://" + *addr
+
+	http.Handle("/", issuer)
+	http.HandleFunc("/authorize", authorize)
+
+	log.Printf("Mock OIDC issuer on %s", issuer.URL)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Method == "GET" {
+		loginPage.Execute(w, map[string]any{"Params": r.URL.Query()})
+		return
+	}
+	if r.FormValue("code_challenge_method") != "S256" || r.FormValue("code_challenge") == "" {
+		http.Error(w, "PKCE S256 required", http.StatusBadRequest)
+		return
+	}
+
+	var groups []string
+	for _, g := range strings.Split(r.FormValue("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	username := r.FormValue("username")
+	code := issuer.Grant(oidctest.Grant{
+		ClientID:    r.FormValue("client_id"),
+		RedirectURI: r.FormValue("redirect_uri"),
+		Challenge:   r.FormValue("code_challenge"),
+		Nonce:       r.FormValue("nonce"),
+		Claims: map[string]any{
+			"sub":                "mock-" + username,
+			"preferred_username": username,
+			"email":              username + "@example.com",
+			"groups":             groups,
+		},
+	})
+
+	v := url.Values{}
+	v.Set("code", code)
+	v.Set("state", r.FormValue("state"))
+	http.Redirect(w, r, r.FormValue("redirect_uri")+"?"+v.Encode(), http.StatusFound)
+}
@@ -4,14 +4,33 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"slices"
 	"strings"
+	"time"
+
+	"cms/storage"
 )
 
 // TagOverride provides optional display overrides for a tag category
 type TagOverride struct {
-	Name      string `json:"name,omitempty"`
-	Icon      string `json:"icon,omitempty"`
-	ImagesDir string `json:"imagesDir,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Icon      string         `json:"icon,omitempty"`
+	ImagesDir string         `json:"imagesDir,omitempty"`
+	Images    *ImageSettings `json:"images,omitempty"`
+}
+
+// ImageSettings controls how uploaded JPEGs and PNGs are processed when
+// the item using them is saved
+type ImageSettings struct {
+	MaxWidth int   `json:"maxWidth,omitempty"` // wider originals are scaled down to this
+	Widths   []int `json:"widths,omitempty"`   // smaller versions to generate for srcsets
+	Quality  int   `json:"quality,omitempty"`  // JPEG and lossy WebP quality, 1-100
+	WebP     *bool `json:"webp,omitempty"`     // also write a WebP version of each JPEG and PNG
+}
+
+// WantWebP reports whether WebP versions should be written
+func (s ImageSettings) WantWebP() bool {
+	return s.WebP == nil || *s.WebP
 }
 
 // ContentTypeConfig is a runtime display struct used by templates
@@ -22,12 +41,116 @@ type ContentTypeConfig struct {
 	ImagesDir string
 	Icon      string
 	FilterTag string
+	Images    ImageSettings
+}
+
+// StorageConfig selects where content is stored
+type StorageConfig struct {
+	Backend string    `json:"backend,omitempty"` // "fs" (default) or "git"
+	Git     GitConfig `json:"git,omitempty"`
+}
+
+// GitConfig configures the git storage backend
+type GitConfig struct {
+	RepoDir string `json:"repoDir,omitempty"` // defaults to the repo containing contentDir
+	Remote  string `json:"remote,omitempty"`  // push after every commit when set
+	Branch  string `json:"branch,omitempty"`
+}
+
+// PublishHook is run whenever an item becomes published: either a webhook
+// that receives a JSON POST, or a shell command
+type PublishHook struct {
+	URL     string `json:"url,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// UploadConfig limits what can be uploaded through the editor
+type UploadConfig struct {
+	MaxSize      int64    `json:"maxSize,omitempty"`      // bytes
+	AllowedTypes []string `json:"allowedTypes,omitempty"` // MIME types, checked against the file's contents
+	StagingTTL   Duration `json:"stagingTTL,omitempty"`   // unsaved uploads are deleted after this long
+	MaxFiles     int      `json:"maxFiles,omitempty"`     // images per upload request, e.g. for a gallery
+}
+
+// AuthConfig controls login requirements
+type AuthConfig struct {
+	RequireAdminTwoFactor bool        `json:"requireAdminTwoFactor,omitempty"` // admins must enroll TOTP before doing anything else
+	TOTPIssuer            string      `json:"totpIssuer,omitempty"`            // name shown in authenticator apps
+	OIDC                  OIDCConfig  `json:"oidc"`
+	LoginLimits           LoginLimits `json:"loginLimits"`
+	Sessions              Sessions    `json:"sessions"`
+	TrustProxyHeaders     bool        `json:"trustProxyHeaders,omitempty"` // take the client IP from X-Forwarded-For
+}
+
+// LoginLimits throttles failed logins per username and per client IP
+type LoginLimits struct {
+	MaxFailures   int      `json:"maxFailures,omitempty"`   // per username before a lockout
+	MaxIPFailures int      `json:"maxIPFailures,omitempty"` // per client IP before a lockout
+	Window        Duration `json:"window,omitempty"`        // failures older than this are forgotten
+	Lockout       Duration `json:"lockout,omitempty"`
+	BaseDelay     Duration `json:"baseDelay,omitempty"` // backoff after the second failure, doubling
+	MaxDelay      Duration `json:"maxDelay,omitempty"`
+}
+
+// Sessions sets how long a login lasts
+type Sessions struct {
+	IdleTimeout     Duration `json:"idleTimeout,omitempty"`     // log out after this long without a request
+	AbsoluteTimeout Duration `json:"absoluteTimeout,omitempty"` // log out this long after login regardless
+	SecureCookie    bool     `json:"secureCookie,omitempty"`    // always mark the cookie Secure, not just over TLS
+}
+
+// Duration is a time.Duration written as a string such as "15m" in
+// config.json
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// OIDCConfig enables single sign-on through an OpenID Connect provider.
+// It is off unless Issuer is set.
+type OIDCConfig struct {
+	Issuer        string            `json:"issuer,omitempty"`
+	ClientID      string            `json:"clientID,omitempty"`
+	ClientSecret  string            `json:"clientSecret,omitempty"` // or OIDC_CLIENT_SECRET; empty for public clients
+	RedirectURL   string            `json:"redirectURL,omitempty"`  // e.g. https://cms.example.com/login/oidc/callback
+	Scopes        []string          `json:"scopes,omitempty"`       // "openid" is always requested
+	UsernameClaim string            `json:"usernameClaim,omitempty"`
+	RoleClaim     string            `json:"roleClaim,omitempty"`
+	RoleMapping   map[string]string `json:"roleMapping,omitempty"` // claim value -> CMS role
+	DefaultRole   string            `json:"defaultRole,omitempty"` // for users no mapping matches; empty refuses them
+	ButtonLabel   string            `json:"buttonLabel,omitempty"`
+}
+
+// Enabled reports whether single sign-on is configured
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
 }
 
 type Settings struct {
-	ContentDir string                 `json:"contentDir"`
-	ImagesDir  string                 `json:"imagesDir"`
-	TagConfig  map[string]TagOverride `json:"tagConfig"`
+	ContentDir   string                 `json:"contentDir"`
+	ImagesDir    string                 `json:"imagesDir"`
+	PublicDir    string                 `json:"publicDir,omitempty"` // the site's public folder, served at "/"; image URLs are paths inside it
+	TagConfig    map[string]TagOverride `json:"tagConfig"`
+	Storage      StorageConfig          `json:"storage"`
+	PublishHooks []PublishHook          `json:"publishHooks,omitempty"`
+	UsersFile    string                 `json:"usersFile,omitempty"`
+	AuditFile    string                 `json:"auditFile,omitempty"`
+	SessionsFile string                 `json:"sessionsFile,omitempty"`
+	TrashDir     string                 `json:"trashDir,omitempty"`   // unused images are moved here
+	HistoryDir   string                 `json:"historyDir,omitempty"` // revisions of content files, kept out of the site's tree
+	Uploads      UploadConfig           `json:"uploads"`
+	Images       ImageSettings          `json:"images"`
+	Auth         AuthConfig             `json:"auth"`
 }
 
 var AppConfig Settings
@@ -47,6 +170,108 @@ func LoadConfig(path string) {
 	if AppConfig.TagConfig == nil {
 		AppConfig.TagConfig = make(map[string]TagOverride)
 	}
+	if AppConfig.UsersFile == "" {
+		AppConfig.UsersFile = "users.json"
+	}
+	if AppConfig.AuditFile == "" {
+		AppConfig.AuditFile = "audit.jsonl"
+	}
+	if AppConfig.SessionsFile == "" {
+		AppConfig.SessionsFile = "sessions.json"
+	}
+	if AppConfig.TrashDir == "" {
+		AppConfig.TrashDir = "trash"
+	}
+	if AppConfig.HistoryDir == "" {
+		AppConfig.HistoryDir = "history"
+	}
+	if AppConfig.PublicDir == "" {
+		AppConfig.PublicDir = "../public"
+	}
+	setDefault(&AppConfig.Uploads.MaxSize, 10<<20)
+	setDefault(&AppConfig.Uploads.StagingTTL, Duration(24*time.Hour))
+	setDefault(&AppConfig.Uploads.MaxFiles, 20)
+	setDefault(&AppConfig.Images.MaxWidth, 2048)
+	setDefault(&AppConfig.Images.Quality, 82)
+	if AppConfig.Images.Widths == nil {
+		AppConfig.Images.Widths = []int{640, 1280}
+	}
+	if AppConfig.Uploads.AllowedTypes == nil {
+		AppConfig.Uploads.AllowedTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+	}
+	if AppConfig.Auth.TOTPIssuer == "" {
+		AppConfig.Auth.TOTPIssuer = "CMS"
+	}
+
+	oidc := &AppConfig.Auth.OIDC
+	if oidc.ClientSecret == "" {
+		oidc.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	}
+	if oidc.Scopes == nil {
+		oidc.Scopes = []string{"openid", "profile", "email"}
+	}
+	if oidc.UsernameClaim == "" {
+		oidc.UsernameClaim = "preferred_username"
+	}
+	if oidc.RoleClaim == "" {
+		oidc.RoleClaim = "groups"
+	}
+	if oidc.ButtonLabel == "" {
+		oidc.ButtonLabel = "Log in with SSO"
+	}
+
+	limits := &AppConfig.Auth.LoginLimits
+	setDefault(&limits.MaxFailures, 5)
+	setDefault(&limits.MaxIPFailures, 20)
+	setDefault(&limits.Window, Duration(15*time.Minute))
+	setDefault(&limits.Lockout, Duration(15*time.Minute))
+	setDefault(&limits.BaseDelay, Duration(time.Second))
+	setDefault(&limits.MaxDelay, Duration(time.Minute))
+
+	sessions := &AppConfig.Auth.Sessions
+	setDefault(&sessions.IdleTimeout, Duration(2*time.Hour))
+	setDefault(&sessions.AbsoluteTimeout, Duration(24*time.Hour))
+}
+
+func setDefault[T comparable](field *T, value T) {
+	var zero T
+	if *field == zero {
+		*field = value
+	}
+}
+
+// SetupStorage switches storage to the backend the config selects. The
+// filesystem backend is the default and needs nothing; git storage uses
+// the repo containing contentDir unless repoDir says otherwise.
+func SetupStorage() error {
+	if AppConfig.Storage.Backend != "git" {
+		return nil
+	}
+	gitCfg := AppConfig.Storage.Git
+	repoDir := gitCfg.RepoDir
+	if repoDir == "" {
+		repoDir = AppConfig.ContentDir
+	}
+	backend, err := storage.NewGitBackend(repoDir, gitCfg.Remote, gitCfg.Branch)
+	if err != nil {
+		return err
+	}
+	storage.SetBackend(backend)
+	log.Printf("Using git storage in %s", backend.RepoDir())
+	return nil
+}
+
+// ImageDirs returns every images folder in the config: the default one
+// first, then any a content type overrides it with, sorted
+func ImageDirs() []string {
+	dirs := []string{AppConfig.ImagesDir}
+	for _, override := range AppConfig.TagConfig {
+		if override.ImagesDir != "" && !slices.Contains(dirs, override.ImagesDir) {
+			dirs = append(dirs, override.ImagesDir)
+		}
+	}
+	slices.Sort(dirs[1:])
+	return dirs
 }
 
 // BuildContentType constructs a ContentTypeConfig for a given tag
@@ -58,6 +283,7 @@ func BuildContentType(tag string) ContentTypeConfig {
 		ImagesDir: AppConfig.ImagesDir,
 		Icon:      "📁",
 		FilterTag: tag,
+		Images:    AppConfig.Images,
 	}
 
 	if override, ok := AppConfig.TagConfig[tag]; ok {
@@ -70,6 +296,20 @@ func BuildContentType(tag string) ContentTypeConfig {
 		if override.ImagesDir != "" {
 			ct.ImagesDir = override.ImagesDir
 		}
+		if images := override.Images; images != nil {
+			if images.MaxWidth != 0 {
+				ct.Images.MaxWidth = images.MaxWidth
+			}
+			if images.Widths != nil {
+				ct.Images.Widths = images.Widths
+			}
+			if images.Quality != 0 {
+				ct.Images.Quality = images.Quality
+			}
+			if images.WebP != nil {
+				ct.Images.WebP = images.WebP
+			}
+		}
 	}
 
 	return ct
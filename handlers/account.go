@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"cms/config"
+	"cms/session"
+	"cms/users"
+
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// renderAccount shows the account page. shown holds secrets that can only
+// be displayed right after they are generated (recovery codes, a new API
+// token), since only their hashes are stored.
+func renderAccount(w http.ResponseWriter, r *http.Request, shown map[string]any) {
+	user, _ := CurrentUser(r)
+	// The user may have changed since RequireLogin loaded it
+	if fresh, ok := userStore.Get(user.Username); ok {
+		user = fresh
+	}
+
+	data := map[string]any{
+		"CSRFToken":    csrfToken(r),
+		"User":         user,
+		"Required":     mustEnrollTwoFactor(user),
+		"RecoveryLeft": len(user.RecoveryCodes),
+		"Tokens":       user.Tokens,
+		"Sessions":     store.List(user.Username),
+		"ThisSession":  currentSessionRef(r),
+		"ContentTypes": contentTypeSlugs(),
+		"Error":        r.URL.Query().Get("error"),
+		"Message":      r.URL.Query().Get("ok"),
+	}
+	for k, v := range shown {
+		data[k] = v
+	}
+
+	// Enrollment started but not confirmed: show the secret to scan
+	if user.TOTPSecret != "" && !user.TOTPEnabled {
+		uri := users.ProvisioningURI(config.AppConfig.Auth.TOTPIssuer, user.Username, user.TOTPSecret)
+		data["Secret"] = user.TOTPSecret
+		data["ProvisioningURI"] = uri
+		if png, err := qrcode.Encode(uri, qrcode.Medium, 240); err == nil {
+			data["QRCode"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		} else {
+			log.Printf("Failed to render QR code: %v", err)
+		}
+	}
+
+	// Secrets and recovery codes shouldn't linger in caches
+	w.Header().Set("Cache-Control", "no-store")
+	tmpl := template.Must(template.ParseFiles("templates/account.html"))
+	tmpl.Execute(w, data)
+}
+
+// redirectAccount goes back to the account page with a message
+func redirectAccount(w http.ResponseWriter, r *http.Request, key, message string) {
+	http.Redirect(w, r, "/account?"+key+"="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// AccountPage handles GET /account
+func AccountPage(w http.ResponseWriter, r *http.Request) {
+	renderAccount(w, r, nil)
+}
+
+// StartTwoFactor handles POST /account/2fa/setup - generates a new secret
+func StartTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, err := userStore.BeginTOTP(currentUser(r)); err != nil {
+		redirectAccount(w, r, "error", err.Error())
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// EnableTwoFactor handles POST /account/2fa/enable - confirms the first code
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	codes, err := userStore.EnableTOTP(currentUser(r), r.FormValue("code"), time.Now())
+	if err != nil {
+		redirectAccount(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "2fa_enable", "")
+	renderAccount(w, r, map[string]any{"RecoveryCodes": codes})
+}
+
+// DisableTwoFactor handles POST /account/2fa/disable
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _ := CurrentUser(r)
+	if _, ok := userStore.Authenticate(user.Username, r.FormValue("password")); !ok {
+		redirectAccount(w, r, "error", "Wrong password")
+		return
+	}
+	if config.AppConfig.Auth.RequireAdminTwoFactor && user.Role == users.RoleAdmin {
+		redirectAccount(w, r, "error", "Two-factor authentication is required for admins")
+		return
+	}
+	if err := userStore.DisableTOTP(user.Username); err != nil {
+		redirectAccount(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "2fa_disable", "")
+	redirectAccount(w, r, "ok", "Two-factor authentication disabled")
+}
+
+// RegenerateRecoveryCodes handles POST /account/2fa/recovery
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	username := currentUser(r)
+	if _, ok := userStore.Authenticate(username, r.FormValue("password")); !ok {
+		redirectAccount(w, r, "error", "Wrong password")
+		return
+	}
+	codes, err := userStore.RegenerateRecoveryCodes(username)
+	if err != nil {
+		redirectAccount(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "recovery_codes", "")
+	renderAccount(w, r, map[string]any{"RecoveryCodes": codes})
+}
+
+// ResetTwoFactor handles POST /users/{username}/2fa/reset - for users who
+// lost both their device and their recovery codes
+func ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	if err := userStore.DisableTOTP(username); err != nil {
+		redirectUsers(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "2fa_reset", username)
+	redirectUsers(w, r, "ok", "Reset two-factor authentication for "+username)
+}
+
+// currentSessionRef returns the Ref of the session making the request
+func currentSessionRef(r *http.Request) string {
+	return session.Ref(currentSessionID(r))
+}
+
+// RevokeSession handles POST /account/sessions/{ref}/delete - signs out
+// one of the user's other devices
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ref := mux.Vars(r)["ref"]
+	if !store.Revoke(currentUser(r), ref) {
+		redirectAccount(w, r, "error", "Session not found")
+		return
+	}
+	recordAccountChange(r, "session_revoke", ref)
+	redirectAccount(w, r, "ok", "Signed out that session")
+}
+
+// SignOutEverywhere handles POST /account/sessions/delete - ends every
+// session of the user, including this one
+func SignOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	n := store.RevokeUser(currentUser(r), "")
+	recordAccountChange(r, "session_revoke", fmt.Sprintf("all %d sessions", n))
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// contentTypeSlugs lists the content types a token can be scoped to
+func contentTypeSlugs() []string {
+	var slugs []string
+	for tag := range discoverTags() {
+		slugs = append(slugs, config.BuildContentType(tag).Slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// CreateToken handles POST /account/tokens
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	// Tokens skip the login, so they wait until 2FA is set up
+	if user, _ := CurrentUser(r); mustEnrollTwoFactor(user) {
+		redirectAccount(w, r, "error", "Set up two-factor authentication before creating API tokens")
+		return
+	}
+	r.ParseForm()
+	readOnly := r.FormValue("access") != "write"
+	plain, token, err := userStore.CreateToken(currentUser(r), r.FormValue("name"), readOnly, r.Form["types"])
+	if err != nil {
+		redirectAccount(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "token_create", token.Name+" ("+token.ID+")")
+	renderAccount(w, r, map[string]any{"NewToken": plain, "NewTokenName": token.Name})
+}
+
+// RevokeToken handles POST /account/tokens/{id}/delete
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if err := userStore.RevokeToken(currentUser(r), mux.Vars(r)["id"]); err != nil {
+		redirectAccount(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "token_revoke", mux.Vars(r)["id"])
+	redirectAccount(w, r, "ok", "Token revoked")
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"cms/audit"
+)
+
+// auditPageLimit caps how many entries the audit page shows at once
+const auditPageLimit = 500
+
+// AuditPage handles GET /audit?user=&action=&from=&to=
+func AuditPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := audit.Filter{
+		User:   q.Get("user"),
+		Action: q.Get("action"),
+		Limit:  auditPageLimit,
+	}
+	// Dates are whole days in server local time; "to" includes that day
+	if from, err := time.ParseInLocation("2006-01-02", q.Get("from"), time.Local); err == nil {
+		filter.From = from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", q.Get("to"), time.Local); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+
+	auditLog := audit.CurrentLog()
+	if auditLog == nil {
+		http.Error(w, "Audit log is not enabled", http.StatusNotFound)
+		return
+	}
+	entries, actions, err := auditLog.Query(filter)
+	if err != nil {
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/audit.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken": csrfToken(r),
+		"Entries":   entries,
+		"Actions":   actions,
+		"Users":     userStore.List(),
+		"Filter":    filter,
+		"From":      q.Get("from"),
+		"To":        q.Get("to"),
+		"Limited":   len(entries) == auditPageLimit,
+	})
+}
@@ -1,53 +1,299 @@
 package handlers
 
 import (
+	"context"
 	"html/template"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
+	"cms/audit"
+	"cms/config"
+	"cms/media"
+	"cms/session"
+	"cms/users"
+
+	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 )
 
-var store *sessions.CookieStore
+var store *session.Store
+var userStore *users.Store
+
+type contextKey string
+
+const (
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "token"
+)
+
+// pendingLoginTTL is how long a correct password is remembered while
+// waiting for the second factor
+const pendingLoginTTL = 5 * time.Minute
 
-func SetStore(s *sessions.CookieStore) {
+func SetStore(s *session.Store) {
 	store = s
 }
 
+// SetUserStore sets the account store used for login and permissions
+func SetUserStore(s *users.Store) {
+	userStore = s
+}
+
 func LoginForm(w http.ResponseWriter, r *http.Request) {
+	errParam := r.URL.Query().Get("error")
+	ssoLabel := ""
+	if oidcProvider != nil {
+		ssoLabel = oidcProvider.Config().ButtonLabel
+	}
+
 	tmpl := template.Must(template.ParseFiles("templates/login.html"))
-	tmpl.Execute(w, nil)
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken": csrfToken(r),
+		"Error":     errParam == "1",
+		"SSOError":  errParam == "sso",
+		"Locked":    errParam == "locked",
+		"SSOLabel":  ssoLabel,
+	})
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
-	user := r.FormValue("username")
+	username := r.FormValue("username")
 	pass := r.FormValue("password")
+	ip := clientIP(r)
 
-	if user == os.Getenv("CMS_USER") && pass == os.Getenv("CMS_PASS") {
+	if loginThrottled(w, r, username, ip, "/login") {
+		return
+	}
+
+	if user, ok := userStore.Authenticate(username, pass); ok {
+		loginSucceeded(username, ip)
 		session, _ := store.Get(r, "session")
-		session.Values["authenticated"] = true
-		session.Save(r, w)
+		if user.HasTwoFactor() {
+			// The password was right, but the session isn't authenticated
+			// until the second step succeeds
+			session.Values["authenticated"] = false
+			session.Values["pendingUser"] = user.Username
+			session.Values["pendingAt"] = time.Now().Unix()
+			session.Save(r, w)
+			audit.Record(audit.Entry{Action: "login_password", User: user.Username, IP: ip, Detail: "waiting for second factor"})
+			http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+			return
+		}
+		startSession(w, r, session, user.Username)
+		audit.Record(audit.Entry{Action: "login", User: user.Username, IP: ip, Detail: "password"})
 		http.Redirect(w, r, "/posts", http.StatusSeeOther)
 	} else {
+		loginFailed(username, ip, "wrong username or password")
 		http.Redirect(w, r, "/login?error=1", http.StatusSeeOther)
 	}
 }
 
+// startSession logs session in as username. The session gets a new ID,
+// so one planted in the browser before login can't be used to ride on it.
+func startSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, username string) {
+	store.Renew(session)
+	delete(session.Values, "pendingUser")
+	delete(session.Values, "pendingAt")
+	session.Values["authenticated"] = true
+	session.Values["username"] = username
+	session.Values["loginIP"] = clientIP(r)
+	// A token seen before login is useless afterwards; CSRF issues a
+	// new one on the next request
+	delete(session.Values, "csrfToken")
+	session.Save(r, w)
+}
+
+// currentSessionID returns the ID of the session making the request
+func currentSessionID(r *http.Request) string {
+	session, _ := store.Get(r, "session")
+	return session.ID
+}
+
+// pendingLogin returns the user waiting on the second login step, if the
+// password step happened recently enough
+func pendingLogin(session *sessions.Session) (string, bool) {
+	username, _ := session.Values["pendingUser"].(string)
+	at, _ := session.Values["pendingAt"].(int64)
+	if username == "" || time.Since(time.Unix(at, 0)) > pendingLoginTTL {
+		return "", false
+	}
+	return username, true
+}
+
+// LoginTwoFactorForm handles GET /login/2fa
+func LoginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session")
+	if _, ok := pendingLogin(session); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/login2fa.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken": csrfToken(r),
+		"Error":     r.URL.Query().Get("error") == "1",
+		"Locked":    r.URL.Query().Get("error") == "locked",
+	})
+}
+
+// LoginTwoFactor handles POST /login/2fa - checks a TOTP or recovery code
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, "session")
+	username, ok := pendingLogin(session)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	ip := clientIP(r)
+	if loginThrottled(w, r, username, ip, "/login/2fa") {
+		return
+	}
+
+	if err := userStore.VerifySecondFactor(username, r.FormValue("code"), time.Now()); err != nil {
+		loginFailed(username, ip, "wrong second factor code")
+		http.Redirect(w, r, "/login/2fa?error=1", http.StatusSeeOther)
+		return
+	}
+	loginSucceeded(username, ip)
+
+	startSession(w, r, session, username)
+	audit.Record(audit.Entry{Action: "login", User: username, IP: ip, Detail: "password and second factor"})
+	http.Redirect(w, r, "/posts", http.StatusSeeOther)
+}
+
+// mustEnrollTwoFactor reports whether the user has to set up 2FA before
+// using the CMS. Single sign-on accounts are left to the issuer's MFA.
+func mustEnrollTwoFactor(user users.User) bool {
+	return config.AppConfig.Auth.RequireAdminTwoFactor &&
+		user.Role == users.RoleAdmin && !user.HasTwoFactor() && user.ExternalID == ""
+}
+
+// isAccountPath reports whether path is the account page or below it
+func isAccountPath(path string) bool {
+	return path == "/account" || strings.HasPrefix(path, "/account/")
+}
+
+// Logout handles POST /logout. It is a POST behind the CSRF check, so
+// another site can't log the user out with a link or an image.
 func Logout(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "session")
-	session.Values["authenticated"] = false
+	if username, _ := session.Values["username"].(string); username != "" {
+		audit.Record(audit.Entry{Action: "logout", User: username, IP: clientIP(r)})
+	}
+	// Uploads that never made it into an item are of no use any more
+	if stage, _ := session.Values["uploadStage"].(string); stage != "" {
+		media.ClearStage(stage)
+	}
+	// Deletes the session on the server, so the old cookie is worthless
+	session.Options.MaxAge = -1
 	session.Save(r, w)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// CurrentUser returns the logged-in user that RequireLogin put in the
+// request context
+func CurrentUser(r *http.Request) (users.User, bool) {
+	user, ok := r.Context().Value(userContextKey).(users.User)
+	return user, ok
+}
+
+// currentUser returns the logged-in username, if any
+func currentUser(r *http.Request) string {
+	user, _ := CurrentUser(r)
+	return user.Username
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// CurrentToken returns the API token the request was authenticated with,
+// if it didn't use the session
+func CurrentToken(r *http.Request) (users.Token, bool) {
+	token, ok := r.Context().Value(tokenContextKey).(users.Token)
+	return token, ok
+}
+
 func RequireLogin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// API clients send a personal token instead of a session cookie
+		if plain, ok := bearerToken(r); ok {
+			user, token, ok := userStore.AuthenticateToken(plain, time.Now())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cms"`)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			// A token must not get an admin past two-factor enrollment
+			if mustEnrollTwoFactor(user) {
+				http.Error(w, "Set up two-factor authentication before using API tokens", http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			ctx = context.WithValue(ctx, tokenContextKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		session, _ := store.Get(r, "session")
 		auth, ok := session.Values["authenticated"].(bool)
 		if !ok || !auth {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		next.ServeHTTP(w, r)
+
+		// Accounts can be removed while a session is still live
+		username, _ := session.Values["username"].(string)
+		user, ok := userStore.Get(username)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		// Admins without 2FA can only reach their account page to set it up
+		if mustEnrollTwoFactor(user) && !isAccountPath(r.URL.Path) {
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Allow wraps a handler so only users whose role grants perm can reach it.
+// Requests made with an API token are also limited to the token's scope.
+func Allow(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := CurrentUser(r)
+		if !ok || !user.Can(perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if token, ok := CurrentToken(r); ok && !token.Allows(perm, mux.Vars(r)["type"]) {
+			http.Error(w, "Token scope does not allow this", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireSession wraps a handler that must not be reachable with an API
+// token, such as account settings and token management
+func RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := CurrentToken(r); ok {
+			http.Error(w, "Not available to API tokens", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cms/config"
+	"cms/users"
+)
+
+// setupAdminWithoutTwoFactor makes 2FA mandatory for admins and returns
+// a store holding one admin who hasn't enrolled yet
+func setupAdminWithoutTwoFactor(t *testing.T) *users.Store {
+	t.Helper()
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig.Auth.RequireAdminTwoFactor = true
+
+	s, err := users.Open(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create("root", "password1", users.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	previousStore := userStore
+	SetUserStore(s)
+	t.Cleanup(func() { SetUserStore(previousStore) })
+	return s
+}
+
+func TestTokenCannotSkipTwoFactorEnrollment(t *testing.T) {
+	s := setupAdminWithoutTwoFactor(t)
+	// Issued before enrollment became mandatory
+	plain, _, err := s.CreateToken("root", "ci", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reached := false
+	h := RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+	for _, path := range []string{"/users", "/account/tokens", "/api/posts"} {
+		r := httptest.NewRequest("POST", path, nil)
+		r.Header.Set("Authorization", "Bearer "+plain)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden || reached {
+			t.Errorf("POST %s with a token = %d, want 403", path, w.Code)
+		}
+	}
+}
+
+func TestCreateTokenNeedsTwoFactor(t *testing.T) {
+	s := setupAdminWithoutTwoFactor(t)
+	root, _ := s.Get("root")
+
+	r := httptest.NewRequest("POST", "/account/tokens", strings.NewReader("name=ci&access=write"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, root))
+	w := httptest.NewRecorder()
+	CreateToken(w, r)
+	if w.Code != http.StatusSeeOther || !strings.Contains(w.Header().Get("Location"), "error=") {
+		t.Errorf("CreateToken = %d to %q, want a redirect with an error", w.Code, w.Header().Get("Location"))
+	}
+	if root, _ := s.Get("root"); len(root.Tokens) != 0 {
+		t.Errorf("a token was created: %+v", root.Tokens)
+	}
+}
+
+func TestIsAccountPath(t *testing.T) {
+	tests := map[string]bool{
+		"/account":            true,
+		"/account/":           true,
+		"/account/2fa/setup":  true,
+		"/accounting":         false,
+		"/accounts/x":         false,
+		"/users":              false,
+		"/api/account":        false,
+		"/account-management": false,
+	}
+	for path, want := range tests {
+		if got := isAccountPath(path); got != want {
+			t.Errorf("isAccountPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
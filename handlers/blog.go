@@ -1,162 +1,251 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
-	"time"
 
+	"cms/audit"
 	"cms/config"
-	"cms/model"
+	"cms/media"
 	"cms/storage"
 
 	"github.com/google/uuid"
-
-	"github.com/gorilla/mux"
 )
 
-func clearTempFolder(path string) error {
-	files, err := os.ReadDir(path)
-	if err != nil {
-		return err
-	}
-	for _, file := range files {
-		if !file.IsDir() {
-			os.Remove(filepath.Join(path, file.Name()))
-		}
+// currentStage returns the staging folder for the request's uploads, or
+// "" if there is none yet. API tokens have no session, so each token gets
+// a stage of its own.
+func currentStage(r *http.Request) string {
+	if token, ok := CurrentToken(r); ok {
+		return "token-" + token.ID
 	}
-	return nil
+	session, _ := store.Get(r, "session")
+	stage, _ := session.Values["uploadStage"].(string)
+	return stage
 }
 
-func CreatePost(w http.ResponseWriter, r *http.Request) {
-	var post model.BlogPost
-	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
-
-	filename := fmt.Sprintf("%s.md", post.Slug)
-	fullPath := filepath.Join(config.AppConfig.ContentDir, filename)
-
-	// Assume: post.CoverImage was "/tmp-preview/scottishcow.jpg"
-
-	tmpPath := strings.TrimPrefix(post.CoverImage, "/")             // => "tmp-preview/scottishcow.jpg"
-	src := filepath.Join("public", tmpPath)                         // => "public/tmp-preview/scottishcow.jpg"
-	destDir := filepath.Join(config.AppConfig.ImagesDir, post.Slug) // => e.g. "public/assets/img/cow"
-	os.MkdirAll(destDir, os.ModePerm)
+// uploadError answers the dropzone with a JSON error it can show
+func uploadError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": message,
+	})
+}
 
-	destFilename := filepath.Base(tmpPath)       // => "scottishcow.jpg"
-	dest := filepath.Join(destDir, destFilename) // => "public/assets/img/cow/scottishcow.jpg"
+// upload is one checked file from an upload request. Status and Error
+// are set when it can't be used.
+type upload struct {
+	Data     []byte
+	MimeType string
+	Filename string
+	Status   int
+	Error    string
+}
 
-	err := os.Rename(src, dest)
+// readUploads reads the images in the request's "image" fields one at a
+// time, straight from the request body, and hands each to use once it is
+// checked, so only one file is held in memory. It answers with a JSON
+// error and returns false if the request as a whole can't be used: no
+// images, more than maxFiles of them, or a broken body. A file that can't
+// be used only carries an error of its own.
+func readUploads(w http.ResponseWriter, r *http.Request, maxFiles int, use func(upload)) bool {
+	maxSize := config.AppConfig.Uploads.MaxSize
+
+	// Leave room for the multipart envelope around the files
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxFiles)*maxSize+1<<20)
+	reader, err := r.MultipartReader()
 	if err != nil {
-		log.Printf("Failed to move image: %v", err)
-		http.Error(w, "Failed to move image", http.StatusInternalServerError)
-		return
+		uploadError(w, http.StatusBadRequest, "Image not provided")
+		return false
 	}
 
-	// Update the image paths to their final public-facing URLs
-	finalPath := fmt.Sprintf("/assets/img/%s/%s", post.Slug, destFilename)
-	post.CoverImage = finalPath
-	post.OGImage.URL = finalPath
-
-	// Now write the frontmatter with the correct path
-	if err := storage.WriteMarkdownWithFrontmatter(fullPath, post); err != nil {
-		http.Error(w, "Failed to write post", http.StatusInternalServerError)
-		return
+	count := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				uploadError(w, http.StatusRequestEntityTooLarge, "Image is larger than "+media.FormatSize(maxSize))
+				return false
+			}
+			uploadError(w, http.StatusBadRequest, "Image not provided")
+			return false
+		}
+		if part.FormName() != "image" || part.FileName() == "" {
+			continue
+		}
+		if count++; count > maxFiles {
+			uploadError(w, http.StatusBadRequest, fmt.Sprintf("At most %d images can be uploaded at once", maxFiles))
+			return false
+		}
+		use(readUpload(part))
 	}
 
-	// Clear temp preview folder
-	if err := clearTempFolder("./public/tmp-preview"); err != nil {
-		log.Printf("Warning: failed to clear tmp-preview folder: %v", err)
+	if count == 0 {
+		uploadError(w, http.StatusBadRequest, "Image not provided")
+		return false
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "🎉 Post written to %s\n", fullPath)
+	return true
 }
 
-func UploadImage(w http.ResponseWriter, r *http.Request) {
-	file, header, err := r.FormFile("image")
-	if err != nil {
-		http.Error(w, "Image not provided", http.StatusBadRequest)
-		return
+// readUpload reads and checks one uploaded file. Its type is sniffed from
+// the first bytes, and a type that can't be allowed is refused before the
+// rest is read.
+func readUpload(part *multipart.Part) upload {
+	u := upload{Filename: part.FileName()}
+	maxSize := config.AppConfig.Uploads.MaxSize
+	allowed := config.AppConfig.Uploads.AllowedTypes
+	fail := func(status int, message string) upload {
+		u.Status, u.Error = status, message
+		return u
+	}
+	notAllowed := func(mimeType string) upload {
+		return fail(http.StatusUnsupportedMediaType, fmt.Sprintf("%s files can't be uploaded. Allowed: %s", mimeType, strings.Join(allowed, ", ")))
 	}
-	defer file.Close()
 
-	// Generate a unique filename
-	ext := filepath.Ext(header.Filename)
-	tempName := uuid.New().String() + ext
-	tmpDir := "./public/tmp-preview"
-	os.MkdirAll(tmpDir, os.ModePerm)
+	head := make([]byte, media.SniffLen)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fail(http.StatusBadRequest, "Failed to read image")
+	}
+	head = head[:n]
+	if mimeType, err := media.CheckHead(head, allowed); err != nil {
+		return notAllowed(mimeType)
+	}
 
-	tmpPath := filepath.Join(tmpDir, tempName)
-	out, err := os.Create(tmpPath)
+	data, err := io.ReadAll(io.LimitReader(io.MultiReader(bytes.NewReader(head), part), maxSize+1))
 	if err != nil {
-		http.Error(w, "Failed to save temporary image", http.StatusInternalServerError)
-		return
+		return fail(http.StatusBadRequest, "Failed to read image")
+	}
+	if int64(len(data)) > maxSize {
+		return fail(http.StatusRequestEntityTooLarge, "Image is larger than "+media.FormatSize(maxSize))
 	}
-	defer out.Close()
-	io.Copy(out, file)
-
-	// Public URL served by Next.js from /public
-	webPath := fmt.Sprintf("/tmp-preview/%s", tempName)
 
-	log.Printf("Uploaded temp image: %s (served as %s)", tmpPath, webPath)
+	// The type comes from the bytes, never from the name or Content-Type
+	// the browser sent
+	mimeType, err := media.Check(data, allowed)
+	switch {
+	case errors.Is(err, media.ErrNotAllowed):
+		return notAllowed(mimeType)
+	case errors.Is(err, media.ErrUnsafeSVG):
+		return fail(http.StatusUnprocessableEntity, "SVG images may not contain scripts, event handlers or embedded documents")
+	case errors.Is(err, media.ErrTooManyPixels):
+		return fail(http.StatusUnprocessableEntity, "The image's dimensions are too large")
+	case err != nil:
+		return fail(http.StatusUnprocessableEntity, "The file isn't a valid image")
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"url": webPath,
-	})
+	u.Data, u.MimeType = data, mimeType
+	return u
 }
 
-func UpdatePost(w http.ResponseWriter, r *http.Request) {
-	slug := mux.Vars(r)["slug"]
-	if slug == "" {
-		http.Error(w, "Missing slug", http.StatusBadRequest)
-		return
+// UploadImage handles POST /api/upload - checks one or more images and
+// keeps them in tmp-preview until the item using them is saved. A single
+// image gets {"url": ...} or {"error": ...}; several get a "files" list
+// with the URL or error of each, in order.
+func UploadImage(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Name  string `json:"name"`
+		URL   string `json:"url,omitempty"`
+		Error string `json:"error,omitempty"`
 	}
+	var results []result
+	var staged []string
+	firstURL := ""
+	var firstErr *upload
+
+	// Each image is staged as soon as it is checked, so its bytes can go
+	// before the next one is read
+	ok := readUploads(w, r, config.AppConfig.Uploads.MaxFiles, func(u upload) {
+		res := result{Name: u.Filename}
+		defer func() { results = append(results, res) }()
+		if u.Error != "" {
+			res.Error = u.Error
+			if firstErr == nil {
+				firstErr = &u
+			}
+			return
+		}
 
-	var post model.BlogPost
-	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		url, path, err := stageUpload(w, r, u)
+		if err != nil {
+			log.Printf("Failed to stage %s: %v", u.Filename, err)
+			u.Status, u.Error = http.StatusInternalServerError, "Failed to save temporary image"
+			res.Error = u.Error
+			if firstErr == nil {
+				firstErr = &u
+			}
+			return
+		}
+		staged = append(staged, path)
+		res.URL = url
+		if firstURL == "" {
+			firstURL = url
+		}
+	})
+	if !ok {
+		// The request was refused as a whole, so nobody will use these
+		for _, path := range staged {
+			storage.Delete(path)
+		}
 		return
 	}
 
-	path := filepath.Join(config.AppConfig.ContentDir, slug+".md")
-	if err := storage.WriteMarkdownWithFrontmatter(path, post); err != nil {
-		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+	if firstURL == "" {
+		uploadError(w, firstErr.Status, firstErr.Error)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	currentTime := time.Now()
-	fmt.Fprintf(w, "✅ Post updated at: %s\n", currentTime.Format(time.RFC3339))
+	response := map[string]any{"url": firstURL}
+	if len(results) > 1 {
+		response["files"] = results
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func DeletePost(w http.ResponseWriter, r *http.Request) {
-	slug := mux.Vars(r)["slug"]
-	if slug == "" {
-		http.Error(w, "Missing slug", http.StatusBadRequest)
-		return
+// stageUpload saves a checked upload in the request's stage, creating the
+// stage on first use, and returns its public URL and where it is stored
+func stageUpload(w http.ResponseWriter, r *http.Request, u upload) (string, string, error) {
+	// Uploads are staged per session until the item using them is saved
+	stage := currentStage(r)
+	if stage == "" {
+		stage = media.NewStage()
+		session, _ := store.Get(r, "session")
+		session.Values["uploadStage"] = stage
+		session.Save(r, w)
 	}
 
-	postPath := filepath.Join(config.AppConfig.ContentDir, slug+".md")
-	imgPath := filepath.Join(config.AppConfig.ImagesDir, slug)
-
-	err := os.Remove(postPath)
+	// Generate a unique filename
+	tempName := uuid.New().String() + media.Extensions[u.MimeType]
+	tmpPath, err := media.StagedPath(stage, tempName)
 	if err != nil {
-		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
-		return
+		return "", "", err
 	}
-	err = os.RemoveAll(imgPath)
-	if err != nil {
-		log.Printf("Warning: failed to delete images for post %s: %v", slug, err)
+	if err := storage.Put(tmpPath, u.Data); err != nil {
+		return "", "", err
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// Public URL served by Next.js from /public
+	webPath := media.StagedURL(stage, tempName)
+
+	log.Printf("Uploaded temp image: %s (served as %s)", tmpPath, webPath)
+	audit.Record(audit.Entry{
+		Action: "upload",
+		User:   currentUser(r),
+		IP:     clientIP(r),
+		After:  strings.Trim(storage.ETag(u.Data), `"`),
+		Detail: u.Filename + " as " + webPath,
+	})
+	return webPath, tmpPath, nil
 }
@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"cms/audit"
 	"cms/config"
+	"cms/index"
+	"cms/media"
 	"cms/model"
+	"cms/publish"
 	"cms/storage"
+	"cms/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -18,43 +24,296 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// hasTag checks if a tag list contains a specific tag
-func hasTag(tags []string, tag string) bool {
-	for _, t := range tags {
-		if t == tag {
-			return true
-		}
+var contentIndex *index.Index
+
+// SetIndex sets the in-memory content index the handlers read from
+func SetIndex(ix *index.Index) {
+	contentIndex = ix
+}
+
+// resolveSlug cleans a slug from the URL or request body and returns it
+// with the item's markdown path. Unsafe slugs are answered with 400, so
+// they never reach storage.
+func resolveSlug(w http.ResponseWriter, ct config.ContentTypeConfig, slug string) (string, string, bool) {
+	clean, err := utils.CleanSlug(slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+	path, err := utils.SlugPath(ct.Directory, clean, ".md")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
 	}
-	return false
+	return clean, path, true
 }
 
-// discoverTags scans all content files and returns unique tags with counts
-func discoverTags() map[string]int {
-	tagCounts := make(map[string]int)
-	dir := config.AppConfig.ContentDir
+// resolveExistingSlug is resolveSlug for routes that only read or delete
+// an item. They also accept files whose names predate slug checks, so
+// those can still be looked at and removed.
+func resolveExistingSlug(w http.ResponseWriter, ct config.ContentTypeConfig, slug string) (string, string, bool) {
+	if _, err := utils.CleanSlug(slug); err != nil {
+		if path, err := utils.LegacySlugPath(ct.Directory, slug, ".md"); err == nil {
+			return slug, path, true
+		}
+	}
+	return resolveSlug(w, ct, slug)
+}
 
-	files, err := os.ReadDir(dir)
+// promoteStaged moves the staged uploads an item refers to (as its cover
+// image, in its gallery or in its body) into the content type's image
+// folder for the item's slug, processing them on the way (see
+// media.Promote), and points the item at their final URLs. It returns the
+// paths it wrote. Every upload is checked before any is moved, and ones
+// from another session's stage are refused.
+func promoteStaged(w http.ResponseWriter, r *http.Request, ct config.ContentTypeConfig, item *model.Content) ([]string, bool) {
+	stage := currentStage(r)
+	destDir, err := utils.SlugPath(ct.ImagesDir, item.Slug, "")
 	if err != nil {
-		log.Printf("Failed to read content dir %s: %v", dir, err)
-		return tagCounts
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
 	}
 
-	for _, f := range files {
-		if f.IsDir() || !strings.HasSuffix(f.Name(), ".md") {
+	urls := []string{item.CoverImage, item.OGImage.URL}
+	for _, img := range item.Gallery {
+		urls = append(urls, img.Src)
+	}
+	urls = append(urls, media.StagedURLPattern.FindAllString(item.Content, -1)...)
+	sources := make(map[string]string) // staged URL -> staged file
+	for _, url := range urls {
+		from, name, ok := media.ParseStagedURL(url)
+		if !ok || sources[url] != "" {
 			continue
 		}
-		fullPath := filepath.Join(dir, f.Name())
-		item, _, err := storage.ReadContent(fullPath)
+		if from != stage {
+			http.Error(w, url+" was uploaded in another session; upload it again", http.StatusBadRequest)
+			return nil, false
+		}
+		src, err := media.StagedPath(stage, name)
+		if err == nil {
+			_, err = storage.Stat(src)
+		}
 		if err != nil {
-			log.Printf("Failed to read %s: %v", f.Name(), err)
+			http.Error(w, url+" is no longer available; upload it again", http.StatusBadRequest)
+			return nil, false
+		}
+		sources[url] = src
+	}
+
+	if len(sources) == 0 {
+		return nil, true
+	}
+	publicPath, ok := media.PublicPath(ct.ImagesDir)
+	if !ok {
+		log.Printf("Images dir %s is not inside publicDir %s, so its images have no URL", ct.ImagesDir, config.AppConfig.PublicDir)
+		http.Error(w, "Images can't be saved: the images folder is not inside the public folder", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	var changed []string
+	finalURLs := make(map[string]string)
+	for url, src := range sources {
+		written, err := media.Promote(src, destDir, ct.Images)
+		changed = append(changed, written...)
+		if err != nil {
+			log.Printf("Failed to move image: %v", err)
+			http.Error(w, "Failed to move image", http.StatusInternalServerError)
+			return nil, false
+		}
+		finalURLs[url] = fmt.Sprintf("%s/%s/%s", publicPath, item.Slug, filepath.Base(src))
+	}
+
+	final := func(url string) string {
+		if f, ok := finalURLs[url]; ok {
+			return f
+		}
+		return url
+	}
+	item.CoverImage = final(item.CoverImage)
+	item.OGImage.URL = final(item.OGImage.URL)
+	item.Content = media.StagedURLPattern.ReplaceAllStringFunc(item.Content, final)
+	for i := range item.Gallery {
+		item.Gallery[i].Src = final(item.Gallery[i].Src)
+	}
+	return changed, true
+}
+
+// measureGallery fills in the width and height of each gallery image
+// stored in one of the images folders. Other images keep whatever size
+// the client sent.
+func measureGallery(item *model.Content) {
+	dirs := config.ImageDirs()
+	for i, img := range item.Gallery {
+		path, ok := media.Locate(dirs, img.Src)
+		if !ok {
 			continue
 		}
-		for _, tag := range item.Tags {
-			tagCounts[tag]++
+		if width, height, ok := media.Dimensions(path); ok {
+			item.Gallery[i].Width, item.Gallery[i].Height = width, height
+		}
+	}
+}
+
+// validateGallery drops gallery entries without an image and trims the
+// text fields
+func validateGallery(item *model.Content) {
+	gallery := item.Gallery[:0]
+	for _, img := range item.Gallery {
+		img.Src = strings.TrimSpace(img.Src)
+		img.Alt = strings.TrimSpace(img.Alt)
+		img.Caption = strings.TrimSpace(img.Caption)
+		if img.Src != "" {
+			gallery = append(gallery, img)
+		}
+	}
+	item.Gallery = gallery
+}
+
+// errOtherType is returned for an item that exists but belongs to
+// another content type
+var errOtherType = errors.New("content is of another type")
+
+// ofType reports whether item belongs to the content type, i.e. carries
+// its filter tag. Every type shares the content folder, so this is what
+// keeps /api/posts/{slug} (and a token scoped to posts) away from photos.
+func ofType(item model.Content, ct config.ContentTypeConfig) bool {
+	return slices.Contains(item.Tags, ct.FilterTag)
+}
+
+// tagWithType adds the content type's filter tag to item's tags if it is
+// missing, like the new item form does, so the item stays listed (and
+// reachable) under the type it was saved through
+func tagWithType(item *model.Content, ct config.ContentTypeConfig) {
+	if !ofType(*item, ct) {
+		item.Tags = append([]string{ct.FilterTag}, item.Tags...)
+	}
+}
+
+// lookupContent returns an item of the content type and its body from the
+// index, falling back to storage (at path) for files the index hasn't
+// picked up yet
+func lookupContent(ct config.ContentTypeConfig, slug, path string) (model.Content, string, error) {
+	if item, ok := contentIndex.Get(slug); ok {
+		if !ofType(item, ct) {
+			return model.Content{}, "", errOtherType
 		}
+		return item, item.Content, nil
 	}
+	return readContent(ct, path)
+}
 
-	return tagCounts
+// readContent reads the item at path straight from storage, like
+// storage.ReadContent, but only if it is of the content type
+func readContent(ct config.ContentTypeConfig, path string) (model.Content, string, error) {
+	item, body, err := storage.ReadContent(path)
+	if err != nil {
+		return model.Content{}, "", err
+	}
+	if !ofType(item, ct) {
+		return model.Content{}, "", errOtherType
+	}
+	return item, body, nil
+}
+
+// commitChange records a content change on storage backends that keep
+// history, naming the content type, slug and logged-in user
+func commitChange(r *http.Request, action, typeSlug, slug string, paths ...string) {
+	user := currentUser(r)
+	message := fmt.Sprintf("%s %s/%s", action, typeSlug, slug)
+	if user != "" {
+		message += " by " + user
+	}
+
+	if err := storage.Commit(message, user, paths...); err != nil {
+		log.Printf("Failed to commit %s/%s: %v", typeSlug, slug, err)
+	}
+}
+
+// recordChange adds a content change to the audit log. before and after
+// are content hashes of the file, empty when it didn't exist.
+func recordChange(r *http.Request, action, typeSlug, slug, before, after string) {
+	audit.Record(audit.Entry{
+		Action: action,
+		User:   currentUser(r),
+		IP:     clientIP(r),
+		Type:   typeSlug,
+		Slug:   slug,
+		Before: before,
+		After:  after,
+	})
+}
+
+// fileHash returns the content hash of the file at path, or "" if it
+// doesn't exist
+func fileHash(path string) string {
+	data, err := storage.Get(path)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(storage.ETag(data), `"`)
+}
+
+// checkIfMatch enforces optimistic concurrency for writes to an existing
+// item. It answers 428 when If-Match is missing and 412 with the current
+// version when the file changed since the client read it.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, path string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+		return false
+	}
+
+	current, body, err := storage.ReadContent(path)
+	if err != nil {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return false
+	}
+
+	if !storage.ETagMatches(ifMatch, current.ETag) {
+		current.Content = body
+		current.Slug = mux.Vars(r)["slug"]
+		current.TypeSlug = mux.Vars(r)["type"]
+
+		w.Header().Set("ETag", current.ETag)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(current)
+		return false
+	}
+
+	return true
+}
+
+// canEdit reports whether the logged-in user may change item
+func canEdit(r *http.Request, item model.Content) bool {
+	user, ok := CurrentUser(r)
+	return ok && user.CanEdit(item.CreatedBy)
+}
+
+// validateStatus checks the status workflow fields and normalizes
+// publishAt to RFC 3339
+func validateStatus(item *model.Content) error {
+	if !model.ValidStatus(item.Status) {
+		return fmt.Errorf("invalid status %q", item.Status)
+	}
+
+	if item.PublishAt != "" {
+		at, err := publish.ParsePublishAt(item.PublishAt)
+		if err != nil {
+			return err
+		}
+		item.PublishAt = at.Format(time.RFC3339)
+	}
+
+	if item.Status == model.StatusScheduled && item.PublishAt == "" {
+		return fmt.Errorf("scheduled items need a publishAt time")
+	}
+	return nil
+}
+
+// discoverTags returns unique tags with counts from the content index
+func discoverTags() map[string]int {
+	return contentIndex.TagCounts()
 }
 
 // Dashboard shows overview of all content types discovered from tags
@@ -76,10 +335,14 @@ func Dashboard(w http.ResponseWriter, r *http.Request) {
 		typeCounts[ct.Slug] = tagCounts[tag]
 	}
 
+	user, _ := CurrentUser(r)
+
 	tmpl := template.Must(template.ParseFiles("templates/dashboard.html"))
 	tmpl.Execute(w, map[string]any{
+		"CSRFToken":    csrfToken(r),
 		"ContentTypes": contentTypes,
 		"Counts":       typeCounts,
+		"User":         user,
 	})
 }
 
@@ -88,54 +351,33 @@ func ListContent(w http.ResponseWriter, r *http.Request) {
 	typeSlug := mux.Vars(r)["type"]
 	ct := config.BuildContentType(typeSlug)
 
-	files, err := os.ReadDir(ct.Directory)
-	if err != nil {
-		http.Error(w, "Failed to list content", http.StatusInternalServerError)
-		return
+	// Filter by content type's tag; the index returns them newest first
+	items := contentIndex.ByTag(ct.FilterTag)
+	for i := range items {
+		items[i].TypeSlug = typeSlug
 	}
 
-	var items []model.Content
-	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(f.Name(), ".md") {
-			fullPath := filepath.Join(ct.Directory, f.Name())
-
-			item, _, err := storage.ReadContent(fullPath)
-			if err != nil {
-				log.Printf("Failed to read %s: %v", f.Name(), err)
-				continue
-			}
-
-			item.Slug = strings.TrimSuffix(f.Name(), ".md")
-			item.TypeSlug = typeSlug
-
-			// Filter by content type's tag
-			if !hasTag(item.Tags, ct.FilterTag) {
-				continue
-			}
-
-			items = append(items, item)
+	// User tag and status filtering (on top of auto-filter)
+	filterTag := r.URL.Query().Get("tag")
+	filterStatus := r.URL.Query().Get("status")
+	var filtered []model.Content
+	for _, item := range items {
+		if filterTag != "" && !slices.Contains(item.Tags, filterTag) {
+			continue
 		}
+		if filterStatus != "" && item.EffectiveStatus() != filterStatus {
+			continue
+		}
+		filtered = append(filtered, item)
 	}
 
-	// Sort by date descending
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].Date > items[j].Date
-	})
-
-	// User tag filtering (on top of auto-filter)
-	filterTag := r.URL.Query().Get("tag")
-	var filtered []model.Content
-	if filterTag != "" {
-		for _, item := range items {
-			for _, tag := range item.Tags {
-				if tag == filterTag {
-					filtered = append(filtered, item)
-					break
-				}
-			}
+	// Files named before slugs were checked can be previewed and deleted
+	// but not edited until they are renamed
+	legacy := map[string]bool{}
+	for _, item := range filtered {
+		if _, err := utils.CleanSlug(item.Slug); err != nil {
+			legacy[item.Slug] = true
 		}
-	} else {
-		filtered = items
 	}
 
 	// Collect all tags, excluding the content type's own FilterTag
@@ -153,23 +395,32 @@ func ListContent(w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Strings(allTags)
 
+	user, _ := CurrentUser(r)
+
 	tmpl := template.Must(template.ParseFiles("templates/listcontent.html"))
 	tmpl.Execute(w, map[string]any{
-		"Items":       filtered,
-		"Tags":        allTags,
-		"ContentType": ct,
+		"CSRFToken":    csrfToken(r),
+		"Items":        filtered,
+		"Legacy":       legacy,
+		"Tags":         allTags,
+		"Statuses":     model.Statuses,
+		"FilterStatus": filterStatus,
+		"ContentType":  ct,
+		"User":         user,
 	})
 }
 
 // GetPreview returns HTML preview of a content item (for HTMX expandable preview)
 func GetPreview(w http.ResponseWriter, r *http.Request) {
 	typeSlug := mux.Vars(r)["type"]
-	slug := mux.Vars(r)["slug"]
-
 	ct := config.BuildContentType(typeSlug)
 
-	path := filepath.Join(ct.Directory, slug+".md")
-	item, body, err := storage.ReadContent(path)
+	slug, path, ok := resolveExistingSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+
+	item, body, err := lookupContent(ct, slug, path)
 	if err != nil {
 		http.Error(w, "Content not found", http.StatusNotFound)
 		return
@@ -179,6 +430,7 @@ func GetPreview(w http.ResponseWriter, r *http.Request) {
 	item.Slug = slug
 	item.TypeSlug = typeSlug
 
+	w.Header().Set("ETag", item.ETag)
 	tmpl := template.Must(template.ParseFiles("templates/partials/preview.html"))
 	tmpl.Execute(w, map[string]any{
 		"Item":        item,
@@ -193,38 +445,92 @@ func NewContentForm(w http.ResponseWriter, r *http.Request) {
 
 	tmpl := template.Must(template.ParseFiles("templates/newcontent.html"))
 	tmpl.Execute(w, map[string]any{
-		"ContentType": ct,
-		"FilterTag":   ct.FilterTag,
+		"CSRFToken":      csrfToken(r),
+		"ContentType":    ct,
+		"FilterTag":      ct.FilterTag,
+		"Statuses":       model.Statuses,
+		"MaxUploadSize":  config.AppConfig.Uploads.MaxSize,
+		"MaxUploadLabel": media.FormatSize(config.AppConfig.Uploads.MaxSize),
+		"MaxUploadFiles": config.AppConfig.Uploads.MaxFiles,
 	})
 }
 
 // EditContentForm handles GET /{type}/edit/{slug}
 func EditContentForm(w http.ResponseWriter, r *http.Request) {
 	typeSlug := mux.Vars(r)["type"]
-	slug := mux.Vars(r)["slug"]
-
 	ct := config.BuildContentType(typeSlug)
 
-	path := filepath.Join(ct.Directory, slug+".md")
-	item, body, err := storage.ReadContent(path)
+	slug, path, ok := resolveSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+
+	item, body, err := lookupContent(ct, slug, path)
 	if err != nil {
 		http.Error(w, "Content not found", http.StatusNotFound)
 		return
 	}
+	if !canEdit(r, item) {
+		http.Error(w, "You can only edit your own items", http.StatusForbidden)
+		return
+	}
 
+	w.Header().Set("ETag", item.ETag)
 	tmpl := template.New("editcontent.html").Funcs(template.FuncMap{
 		"join": strings.Join,
 	})
 	tmpl = template.Must(tmpl.ParseFiles("templates/editcontent.html"))
 
+	// datetime-local inputs want local time without a zone
+	publishAtInput := ""
+	if at, err := publish.ParsePublishAt(item.PublishAt); err == nil {
+		publishAtInput = at.Local().Format("2006-01-02T15:04")
+	}
+
 	tmpl.Execute(w, map[string]interface{}{
-		"Item":        item,
-		"Slug":        slug,
-		"Body":        body,
-		"ContentType": ct,
+		"CSRFToken":      csrfToken(r),
+		"Item":           item,
+		"Slug":           slug,
+		"Body":           body,
+		"ContentType":    ct,
+		"Statuses":       model.Statuses,
+		"PublishAtInput": publishAtInput,
+		"MaxUploadSize":  config.AppConfig.Uploads.MaxSize,
+		"MaxUploadLabel": media.FormatSize(config.AppConfig.Uploads.MaxSize),
+		"MaxUploadFiles": config.AppConfig.Uploads.MaxFiles,
 	})
 }
 
+// GetContent handles GET /api/{type}/{slug}
+func GetContent(w http.ResponseWriter, r *http.Request) {
+	typeSlug := mux.Vars(r)["type"]
+	ct := config.BuildContentType(typeSlug)
+
+	slug, path, ok := resolveExistingSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+
+	item, body, err := lookupContent(ct, slug, path)
+	if err != nil {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", item.ETag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && storage.ETagMatches(inm, item.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	item.Content = body
+	item.Slug = slug
+	item.TypeSlug = typeSlug
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
 // CreateContent handles POST /api/{type}
 func CreateContent(w http.ResponseWriter, r *http.Request) {
 	typeSlug := mux.Vars(r)["type"]
@@ -235,39 +541,51 @@ func CreateContent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if err := validateStatus(&item); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	filename := fmt.Sprintf("%s.md", item.Slug)
-	fullPath := filepath.Join(ct.Directory, filename)
+	item.CreatedBy = currentUser(r)
+	tagWithType(&item, ct)
 
-	// Handle image moving from temp folder
-	if strings.HasPrefix(item.CoverImage, "/tmp-preview/") {
-		tmpPath := strings.TrimPrefix(item.CoverImage, "/")
-		src := filepath.Join("public", tmpPath)
-		destDir := filepath.Join(ct.ImagesDir, item.Slug)
-		os.MkdirAll(destDir, os.ModePerm)
+	slug, fullPath, ok := resolveSlug(w, ct, item.Slug)
+	if !ok {
+		return
+	}
+	item.Slug = slug
+	changed := []string{fullPath}
 
-		destFilename := filepath.Base(tmpPath)
-		dest := filepath.Join(destDir, destFilename)
+	unlock := storage.Lock(fullPath)
+	defer unlock()
 
-		if err := os.Rename(src, dest); err != nil {
-			log.Printf("Failed to move image: %v", err)
-			http.Error(w, "Failed to move image", http.StatusInternalServerError)
-			return
-		}
+	// Creating never replaces an item: that would skip the ownership and
+	// If-Match checks an update goes through
+	if _, err := storage.Stat(fullPath); err == nil {
+		http.Error(w, "Content already exists", http.StatusConflict)
+		return
+	}
 
-		// Determine the public path based on content type
-		publicPath := strings.TrimPrefix(ct.ImagesDir, "../public")
-		finalPath := fmt.Sprintf("%s/%s/%s", publicPath, item.Slug, destFilename)
-		item.CoverImage = finalPath
-		item.OGImage.URL = finalPath
+	validateGallery(&item)
+	moved, ok := promoteStaged(w, r, ct, &item)
+	if !ok {
+		return
 	}
+	measureGallery(&item)
+	changed = append(changed, moved...)
 
 	if err := storage.WriteContent(fullPath, item); err != nil {
 		http.Error(w, "Failed to write content", http.StatusInternalServerError)
 		return
 	}
 
-	clearTempFolder("./public/tmp-preview")
+	commitChange(r, "Create", typeSlug, item.Slug, changed...)
+	recordChange(r, "create", typeSlug, item.Slug, "", fileHash(fullPath))
+	contentIndex.Refresh(item.Slug)
+
+	if item.EffectiveStatus() == model.StatusPublished {
+		publish.FireHooks(item, typeSlug)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "Content created: %s\n", fullPath)
@@ -276,22 +594,75 @@ func CreateContent(w http.ResponseWriter, r *http.Request) {
 // UpdateContent handles PUT /api/{type}/{slug}
 func UpdateContent(w http.ResponseWriter, r *http.Request) {
 	typeSlug := mux.Vars(r)["type"]
-	slug := mux.Vars(r)["slug"]
-
 	ct := config.BuildContentType(typeSlug)
 
+	slug, path, ok := resolveSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+
 	var item model.Content
 	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if err := validateStatus(&item); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unlock := storage.Lock(path)
+	defer unlock()
+
+	previous, _, err := readContent(ct, path)
+	if err != nil {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+	if !canEdit(r, previous) {
+		http.Error(w, "You can only edit your own items", http.StatusForbidden)
+		return
+	}
 
-	path := filepath.Join(ct.Directory, slug+".md")
+	if !checkIfMatch(w, r, path) {
+		return
+	}
+
+	// Ownership is server-side and never changes on update
+	item.CreatedBy = previous.CreatedBy
+	// Clients that don't know about galleries leave them alone; an
+	// empty list removes one
+	if item.Gallery == nil {
+		item.Gallery = previous.Gallery
+	}
+	validateGallery(&item)
+	tagWithType(&item, ct)
+	wasPublished := previous.EffectiveStatus() == model.StatusPublished
+
+	item.Slug = slug
+	moved, ok := promoteStaged(w, r, ct, &item)
+	if !ok {
+		return
+	}
+	measureGallery(&item)
+
+	before := fileHash(path)
 	if err := storage.WriteContent(path, item); err != nil {
 		http.Error(w, "Failed to update content", http.StatusInternalServerError)
 		return
 	}
 
+	commitChange(r, "Update", typeSlug, slug, append([]string{path}, moved...)...)
+	recordChange(r, "update", typeSlug, slug, before, fileHash(path))
+	contentIndex.Refresh(slug)
+
+	if !wasPublished && item.EffectiveStatus() == model.StatusPublished {
+		publish.FireHooks(item, typeSlug)
+	}
+
+	if updated, _, err := storage.ReadContent(path); err == nil {
+		w.Header().Set("ETag", updated.ETag)
+	}
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Updated at: %s\n", time.Now().Format(time.RFC3339))
 }
@@ -299,19 +670,55 @@ func UpdateContent(w http.ResponseWriter, r *http.Request) {
 // DeleteContent handles DELETE /api/{type}/{slug}
 func DeleteContent(w http.ResponseWriter, r *http.Request) {
 	typeSlug := mux.Vars(r)["type"]
-	slug := mux.Vars(r)["slug"]
-
 	ct := config.BuildContentType(typeSlug)
 
-	contentPath := filepath.Join(ct.Directory, slug+".md")
-	imgPath := filepath.Join(ct.ImagesDir, slug)
+	slug, contentPath, ok := resolveExistingSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+	// Legacy names never got an image folder
+	paths := []string{contentPath}
+	imgPath, err := utils.SlugPath(ct.ImagesDir, slug, "")
+	if err == nil {
+		paths = append(paths, imgPath)
+	}
 
-	if err := os.Remove(contentPath); err != nil {
+	unlock := storage.Lock(contentPath)
+	defer unlock()
+
+	if _, _, err := readContent(ct, contentPath); err != nil {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+	if !checkIfMatch(w, r, contentPath) {
+		return
+	}
+
+	before := fileHash(contentPath)
+	if err := storage.Delete(contentPath); err != nil {
 		http.Error(w, "Failed to delete content", http.StatusInternalServerError)
 		return
 	}
 
-	os.RemoveAll(imgPath) // Best effort for images
+	if imgPath != "" {
+		storage.Delete(imgPath) // Best effort for images
+	}
+
+	commitChange(r, "Delete", typeSlug, slug, paths...)
+	recordChange(r, "delete", typeSlug, slug, before, "")
+	contentIndex.Refresh(slug)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Reindex handles POST /api/reindex - rebuilds the content index from storage
+func Reindex(w http.ResponseWriter, r *http.Request) {
+	if err := contentIndex.Reindex(); err != nil {
+		http.Error(w, "Failed to reindex content", http.StatusInternalServerError)
+		return
+	}
 
+	audit.Record(audit.Entry{Action: "reindex", User: currentUser(r), IP: clientIP(r)})
 	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Reindexed %d items\n", len(contentIndex.All()))
 }
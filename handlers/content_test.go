@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cms/config"
+	"cms/index"
+	"cms/storage"
+	"cms/users"
+
+	"github.com/gorilla/mux"
+)
+
+// setupTypedContent writes a post and a photo into a fresh content folder
+// and indexes them
+func setupTypedContent(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	storage.SetHistoryDir(t.TempDir())
+	t.Cleanup(func() { storage.SetHistoryDir("history") })
+	files := map[string]string{
+		"hello.md":  "---\ntitle: Hello\ntags: [posts]\ncreatedBy: alice\n---\n\nA post\n",
+		"sunset.md": "---\ntitle: Sunset\ntags: [photos]\ncreatedBy: alice\n---\n\nA photo\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig = config.Settings{
+		ContentDir: dir,
+		ImagesDir:  filepath.Join(dir, "img"),
+		TagConfig:  map[string]config.TagOverride{},
+	}
+
+	ix := index.New(dir)
+	if err := ix.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	SetIndex(ix)
+}
+
+// tokenRequest builds a request as RequireLogin would pass it on for an
+// API token scoped to types
+func tokenRequest(method, typeSlug, slug string, types ...string) *http.Request {
+	r := httptest.NewRequest(method, "/api/"+typeSlug+"/"+url.PathEscape(slug), strings.NewReader(`{"title":"Changed","tags":["posts"]}`))
+	r.Header.Set("If-Match", "*")
+	user := users.User{Username: "alice", Role: users.RoleEditor}
+	token := users.Token{ID: "t1", Name: "ci", Types: types}
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, tokenContextKey, token)
+	return mux.SetURLVars(r.WithContext(ctx), map[string]string{"type": typeSlug, "slug": slug})
+}
+
+func TestScopedTokenStaysInItsType(t *testing.T) {
+	setupTypedContent(t)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		typ     string
+		slug    string
+		want    int
+	}{
+		{"read own type", Allow(users.PermRead, GetContent), "GET", "posts", "hello", http.StatusOK},
+		{"read other type through own", Allow(users.PermRead, GetContent), "GET", "posts", "sunset", http.StatusNotFound},
+		{"read other type directly", Allow(users.PermRead, GetContent), "GET", "photos", "sunset", http.StatusForbidden},
+		{"preview other type", Allow(users.PermRead, GetPreview), "GET", "posts", "sunset", http.StatusNotFound},
+		{"history of other type", Allow(users.PermRead, ContentHistory), "GET", "posts", "sunset", http.StatusNotFound},
+		{"update other type", Allow(users.PermEditOwn, UpdateContent), "PUT", "posts", "sunset", http.StatusNotFound},
+		{"restore other type", Allow(users.PermEditOwn, RestoreRevision), "POST", "posts", "sunset", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tokenRequest(tt.method, tt.typ, tt.slug, "posts")
+			if tt.name == "restore other type" {
+				r = mux.SetURLVars(r, map[string]string{"type": tt.typ, "slug": tt.slug, "rev": "20250101T000000.000000000Z"})
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s /api/%s/%s = %d, want %d (%s)", tt.method, tt.typ, tt.slug, w.Code, tt.want, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.AppConfig.ContentDir, "sunset.md"))
+	if err != nil || !strings.Contains(string(data), "title: Sunset") {
+		t.Errorf("sunset.md was changed: %q, %v", data, err)
+	}
+}
+
+func TestDeleteOtherTypeIsNotFound(t *testing.T) {
+	setupTypedContent(t)
+
+	r := tokenRequest("DELETE", "posts", "sunset")
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, users.User{Username: "root", Role: users.RoleAdmin}))
+	w := httptest.NewRecorder()
+	Allow(users.PermDelete, DeleteContent)(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DELETE /api/posts/sunset = %d, want 404", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(config.AppConfig.ContentDir, "sunset.md")); err != nil {
+		t.Errorf("sunset.md was deleted: %v", err)
+	}
+}
+
+func TestCreateTagsItemWithItsType(t *testing.T) {
+	setupTypedContent(t)
+
+	r := tokenRequest("POST", "posts", "", "posts")
+	r.Body = io.NopCloser(strings.NewReader(`{"title":"Untagged","slug":"untagged"}`))
+	w := httptest.NewRecorder()
+	Allow(users.PermCreate, CreateContent)(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/posts = %d (%s)", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+
+	w = httptest.NewRecorder()
+	Allow(users.PermRead, GetContent)(w, tokenRequest("GET", "posts", "untagged", "posts"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"tags":["posts"]`) {
+		t.Errorf("GET /api/posts/untagged = %d %s, want it tagged posts", w.Code, w.Body.String())
+	}
+}
+
+func TestGitCommitsEachChange(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	base := t.TempDir()
+	work, remote := filepath.Join(base, "work"), filepath.Join(base, "remote.git")
+	git(base, "init", "--bare", "-b", "main", remote)
+	git(base, "init", "-b", "main", work)
+	git(work, "config", "user.name", "CMS")
+	git(work, "config", "user.email", "cms@example.com")
+	git(work, "config", "commit.gpgsign", "false")
+	git(work, "remote", "add", "origin", remote)
+	git(work, "commit", "--allow-empty", "-m", "Initial commit")
+
+	contentDir := filepath.Join(work, "_content")
+	if err := os.Mkdir(contentDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := storage.NewGitBackend(contentDir, "origin", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.SetHistoryDir(t.TempDir())
+	t.Cleanup(func() { storage.SetHistoryDir("history") })
+	previousBackend := storage.CurrentBackend()
+	storage.SetBackend(backend)
+	t.Cleanup(func() { storage.SetBackend(previousBackend) })
+
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig = config.Settings{
+		ContentDir: contentDir,
+		ImagesDir:  filepath.Join(work, "img"),
+		TagConfig:  map[string]config.TagOverride{},
+	}
+	ix := index.New(contentDir)
+	SetIndex(ix)
+
+	steps := []struct {
+		handler http.HandlerFunc
+		method  string
+		slug    string
+		body    string
+		want    string
+	}{
+		{Allow(users.PermCreate, CreateContent), "POST", "", `{"title":"Hello","slug":"hello"}`, "Create posts/hello by alice"},
+		{Allow(users.PermEditOwn, UpdateContent), "PUT", "hello", `{"title":"Hello again"}`, "Update posts/hello by alice"},
+		{Allow(users.PermDelete, DeleteContent), "DELETE", "hello", "", "Delete posts/hello by alice"},
+	}
+	for i, step := range steps {
+		r := tokenRequest(step.method, "posts", step.slug)
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, users.User{Username: "alice", Role: users.RoleAdmin}))
+		r.Body = io.NopCloser(strings.NewReader(step.body))
+		w := httptest.NewRecorder()
+		step.handler(w, r)
+		if w.Code >= 300 {
+			t.Fatalf("%s = %d (%s)", step.want, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+
+		if got := git(work, "rev-list", "--count", "HEAD"); got != strconv.Itoa(i+2) {
+			t.Errorf("after %q there are %s commits, want %d", step.want, got, i+2)
+		}
+		if got := git(work, "log", "-1", "--format=%s|%an", "--", "_content/hello.md"); got != step.want+"|alice" {
+			t.Errorf("last commit = %q, want %q by alice", got, step.want)
+		}
+		if local, pushed := git(work, "rev-parse", "HEAD"), git(remote, "rev-parse", "main"); local != pushed {
+			t.Errorf("after %q the remote is at %s, want %s", step.want, pushed, local)
+		}
+		// Revisions are kept outside the site's repository
+		if status := git(work, "status", "--porcelain", "--untracked-files=all"); status != "" {
+			t.Errorf("after %q the work tree isn't clean:\n%s", step.want, status)
+		}
+	}
+}
+
+func TestLegacyFileNameCanBeReadAndDeleted(t *testing.T) {
+	setupTypedContent(t)
+	dir := config.AppConfig.ContentDir
+	legacy := filepath.Join(dir, "my old post.md")
+	if err := os.WriteFile(legacy, []byte("---\ntitle: Old\ntags: [posts]\n---\n\nFrom before slugs were checked\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := contentIndex.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	admin := func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), userContextKey, users.User{Username: "root", Role: users.RoleAdmin}))
+	}
+
+	w := httptest.NewRecorder()
+	Allow(users.PermRead, GetContent)(w, tokenRequest("GET", "posts", "my old post"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title":"Old"`) {
+		t.Errorf("GET = %d %s, want the item", w.Code, w.Body.String())
+	}
+
+	// Saving would need a valid name
+	w = httptest.NewRecorder()
+	Allow(users.PermEditOwn, UpdateContent)(w, admin(tokenRequest("PUT", "posts", "my old post")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("PUT = %d, want 400", w.Code)
+	}
+
+	// Hostile names still aren't read through the legacy path
+	for _, slug := range []string{"../posts/hello", ".hidden", "missing post"} {
+		w = httptest.NewRecorder()
+		Allow(users.PermRead, GetContent)(w, tokenRequest("GET", "posts", slug))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %q = %d, want 400", slug, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	Allow(users.PermDelete, DeleteContent)(w, admin(tokenRequest("DELETE", "posts", "my old post")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE = %d (%s)", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("the file is still there: %v", err)
+	}
+}
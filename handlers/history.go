@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"cms/config"
+	"cms/model"
+	"cms/storage"
+	"cms/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// readVersion returns the current file when rev is "current", otherwise
+// the stored revision
+func readVersion(path, rev string) ([]byte, error) {
+	if rev == "current" {
+		return storage.Get(path)
+	}
+	return storage.ReadRevision(path, rev)
+}
+
+// historyOfType reports whether the item at path is of the content type.
+// A deleted item is judged by its latest revision.
+func historyOfType(ct config.ContentTypeConfig, path string, revisions []storage.Revision) bool {
+	if item, _, err := storage.ReadContent(path); err == nil {
+		return ofType(item, ct)
+	}
+	if len(revisions) == 0 {
+		return false
+	}
+	data, err := storage.ReadRevision(path, revisions[0].ID)
+	if err != nil {
+		return false
+	}
+	item, _, err := storage.ParseContent(data, revisions[0].ID)
+	return err == nil && ofType(item, ct)
+}
+
+// ContentHistory handles GET /{type}/history/{slug} - lists revisions and
+// shows a line diff between ?from= and ?to=
+func ContentHistory(w http.ResponseWriter, r *http.Request) {
+	typeSlug := mux.Vars(r)["type"]
+	ct := config.BuildContentType(typeSlug)
+
+	slug, path, ok := resolveExistingSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+
+	revisions, _ := storage.ListRevisions(path)
+	if !historyOfType(ct, path, revisions) {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = "current"
+	}
+	if from == "" && len(revisions) > 1 {
+		from = revisions[1].ID
+	}
+
+	var diff []utils.DiffLine
+	if from != "" {
+		before, err := readVersion(path, from)
+		if err != nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		after, err := readVersion(path, to)
+		if err != nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		diff = utils.DiffLines(string(before), string(after))
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/history.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken":   csrfToken(r),
+		"ContentType": ct,
+		"Slug":        slug,
+		"Revisions":   revisions,
+		"From":        from,
+		"To":          to,
+		"Diff":        diff,
+	})
+}
+
+// RestoreRevision handles POST /api/{type}/{slug}/restore/{rev}
+func RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	typeSlug := mux.Vars(r)["type"]
+	rev := mux.Vars(r)["rev"]
+
+	ct := config.BuildContentType(typeSlug)
+	slug, path, ok := resolveSlug(w, ct, mux.Vars(r)["slug"])
+	if !ok {
+		return
+	}
+
+	unlock := storage.Lock(path)
+	defer unlock()
+
+	data, err := storage.ReadRevision(path, rev)
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	// A deleted item has no owner on disk, so only editors can bring it back
+	current, _, err := storage.ReadContent(path)
+	if err == nil && !ofType(current, ct) {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		current = model.Content{}
+	}
+	if !canEdit(r, current) {
+		http.Error(w, "You can only restore your own items", http.StatusForbidden)
+		return
+	}
+
+	item, _, err := storage.ParseContent(data, rev)
+	if err != nil {
+		http.Error(w, "Revision is not valid content", http.StatusUnprocessableEntity)
+		return
+	}
+	if !ofType(item, ct) {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+
+	// The revision is written back exactly as it was, so it can't be one
+	// that would hand the item to someone else
+	if current.CreatedBy != "" && item.CreatedBy != current.CreatedBy {
+		http.Error(w, "This revision has a different owner; edit the item instead", http.StatusConflict)
+		return
+	}
+
+	before := fileHash(path)
+	if err := storage.RestoreRevision(path, rev); err != nil {
+		http.Error(w, "Failed to restore content", http.StatusInternalServerError)
+		return
+	}
+
+	commitChange(r, "Restore", typeSlug, slug, path)
+	recordChange(r, "restore", typeSlug, slug, before, fileHash(path))
+	contentIndex.Refresh(slug)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Restored revision %s\n", rev)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cms/audit"
+	"cms/config"
+	"cms/media"
+	"cms/storage"
+
+	"github.com/google/uuid"
+)
+
+// mediaAssets lists every image with the items that use it, filtered by
+// ?q= on the file and folder name
+func mediaAssets(r *http.Request) []media.Asset {
+	assets := media.Scan(config.ImageDirs())
+	media.Link(assets, contentIndex.All())
+
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if q == "" {
+		return assets
+	}
+	var filtered []media.Asset
+	for _, a := range assets {
+		if strings.Contains(strings.ToLower(a.Folder+"/"+a.Name), q) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// MediaPage handles GET /media?q=
+func MediaPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := CurrentUser(r)
+
+	tmpl := template.Must(template.New("media.html").Funcs(template.FuncMap{
+		"formatSize": media.FormatSize,
+	}).ParseFiles("templates/media.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken":      csrfToken(r),
+		"Assets":         mediaAssets(r),
+		"Query":          r.URL.Query().Get("q"),
+		"User":           user,
+		"MaxUploadSize":  config.AppConfig.Uploads.MaxSize,
+		"MaxUploadLabel": media.FormatSize(config.AppConfig.Uploads.MaxSize),
+	})
+}
+
+// MediaAPI handles GET /api/media?q= for the editor's image picker
+func MediaAPI(w http.ResponseWriter, r *http.Request) {
+	assets := mediaAssets(r)
+	if assets == nil {
+		assets = []media.Asset{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assets)
+}
+
+// UploadMedia handles POST /api/media - adds an image straight to the
+// media library, processed like an item's images, and returns its URL
+func UploadMedia(w http.ResponseWriter, r *http.Request) {
+	var u upload
+	if !readUploads(w, r, 1, func(read upload) { u = read }) {
+		return
+	}
+	if u.Error != "" {
+		uploadError(w, u.Status, u.Error)
+		return
+	}
+	data, mimeType, filename := u.Data, u.MimeType, u.Filename
+
+	publicPath, ok := media.PublicPath(config.AppConfig.ImagesDir)
+	if !ok {
+		log.Printf("Images dir %s is not inside publicDir %s, so its images have no URL", config.AppConfig.ImagesDir, config.AppConfig.PublicDir)
+		uploadError(w, http.StatusInternalServerError, "The images folder is not inside the public folder")
+		return
+	}
+	name := uuid.New().String() + media.Extensions[mimeType]
+	dir := filepath.Join(config.AppConfig.ImagesDir, media.LibraryDir)
+	written, err := media.Save(data, name, dir, config.AppConfig.Images)
+	if err != nil {
+		log.Printf("Failed to save %s to the media library: %v", filename, err)
+		uploadError(w, http.StatusInternalServerError, "Failed to save image")
+		return
+	}
+	url := publicPath + "/" + media.LibraryDir + "/" + name
+
+	commitChange(r, "Upload", media.LibraryDir, name, written...)
+	audit.Record(audit.Entry{
+		Action: "upload",
+		User:   currentUser(r),
+		IP:     clientIP(r),
+		After:  strings.Trim(storage.ETag(data), `"`),
+		Detail: filename + " to the media library as " + url,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": url,
+	})
+}
+
+// MediaCheckPage handles GET /media/check - images no item uses and
+// image references that point at missing files
+func MediaCheckPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("mediacheck.html").Funcs(template.FuncMap{
+		"formatSize": media.FormatSize,
+	}).ParseFiles("templates/mediacheck.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken": csrfToken(r),
+		"Report":    media.CheckAssets(config.ImageDirs(), contentIndex.All()),
+		"TrashDir":  config.AppConfig.TrashDir,
+		"PublicDir": config.AppConfig.PublicDir,
+		"Trashed":   r.URL.Query().Get("trashed"),
+	})
+}
+
+// TrashMedia handles POST /media/trash - moves the selected unused images
+// to the trash. Images an item started using since the page was loaded
+// are left alone.
+func TrashMedia(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	selected := r.PostForm["url"]
+
+	report := media.CheckAssets(config.ImageDirs(), contentIndex.All())
+	if len(report.Unchecked) > 0 {
+		http.Error(w, "Images can't be moved to the trash while some images folders are outside publicDir", http.StatusConflict)
+		return
+	}
+
+	var trash []media.Asset
+	for _, a := range report.Orphans {
+		if slices.Contains(selected, a.URL) {
+			trash = append(trash, a)
+		}
+	}
+	if len(trash) == 0 {
+		http.Redirect(w, r, "/media/check", http.StatusSeeOther)
+		return
+	}
+
+	changed, err := media.Trash(trash, config.AppConfig.TrashDir, time.Now())
+	if err != nil {
+		log.Printf("Failed to move unused images to the trash (%d files changed): %v", len(changed), err)
+		http.Error(w, "Some images could not be moved to the trash", http.StatusInternalServerError)
+		return
+	}
+
+	user := currentUser(r)
+	if err := storage.Commit(fmt.Sprintf("Move %d unused images to the trash by %s", len(trash), user), user, changed...); err != nil {
+		log.Printf("Failed to commit trashed images: %v", err)
+	}
+	urls := make([]string, len(trash))
+	for i, a := range trash {
+		urls[i] = a.URL
+	}
+	audit.Record(audit.Entry{
+		Action: "media_trash",
+		User:   user,
+		IP:     clientIP(r),
+		Detail: strings.Join(urls, ", "),
+	})
+
+	http.Redirect(w, r, fmt.Sprintf("/media/check?trashed=%d", len(trash)), http.StatusSeeOther)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"cms/config"
+	"cms/index"
+	"cms/media"
+)
+
+func TestMediaAPIFilter(t *testing.T) {
+	public := t.TempDir()
+	media.SetPublicDir(public)
+	t.Cleanup(func() { media.SetPublicDir("../public") })
+	images := filepath.Join(public, "assets", "img")
+	for _, name := range []string{"Sunset-Beach/cover.png", "sunset-beach/detail.png", "trip/Map.png", media.LibraryDir + "/logo.png", "favicon.png"} {
+		path := filepath.Join(images, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(f, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+		f.Close()
+	}
+
+	content := t.TempDir()
+	os.WriteFile(filepath.Join(content, "trip.md"), []byte("---\ntitle: Trip\ntags: [posts]\ncoverImage: /assets/img/trip/Map.png\n---\n\nBody\n"), 0o644)
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig = config.Settings{ContentDir: content, ImagesDir: images}
+	ix := index.New(content)
+	if err := ix.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	SetIndex(ix)
+
+	tests := []struct {
+		q    string
+		want []string // folder/name
+	}{
+		{"", []string{"/favicon.png", "Sunset-Beach/cover.png", "_library/logo.png", "sunset-beach/detail.png", "trip/Map.png"}},
+		// Case-insensitive, on the folder and the name together
+		{"sunset", []string{"Sunset-Beach/cover.png", "sunset-beach/detail.png"}},
+		{"  MAP ", []string{"trip/Map.png"}},
+		{"beach/cover", []string{"Sunset-Beach/cover.png"}},
+		{"library", []string{"_library/logo.png"}},
+		{"nothing", []string{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/media?q="+url.QueryEscape(tt.q), nil)
+		w := httptest.NewRecorder()
+		MediaAPI(w, r)
+
+		var assets []media.Asset
+		if err := json.Unmarshal(w.Body.Bytes(), &assets); err != nil {
+			t.Fatalf("q=%q: %v: %s", tt.q, err, w.Body)
+		}
+		got := []string{}
+		for _, a := range assets {
+			got = append(got, a.Folder+"/"+a.Name)
+			if a.Name == "Map.png" && (len(a.UsedBy) != 1 || a.UsedBy[0].Type != "posts") {
+				t.Errorf("Map.png used by %+v, want the trip post", a.UsedBy)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("q=%q: got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cms/audit"
+	"cms/oidc"
+)
+
+// oidcFlowTTL is how long a user has to finish logging in at the issuer
+const oidcFlowTTL = 10 * time.Minute
+
+var oidcProvider *oidc.Provider
+
+// SetOIDCProvider enables single sign-on through p
+func SetOIDCProvider(p *oidc.Provider) {
+	oidcProvider = p
+}
+
+// OIDCLogin handles GET /login/oidc - redirects to the identity provider
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := oidcProvider.AuthURL(r.Context(), flow)
+	if err != nil {
+		log.Printf("SSO login failed: %v", err)
+		http.Redirect(w, r, "/login?error=sso", http.StatusSeeOther)
+		return
+	}
+
+	session, _ := store.Get(r, "session")
+	session.Values["oidcState"] = flow.State
+	session.Values["oidcNonce"] = flow.Nonce
+	session.Values["oidcVerifier"] = flow.Verifier
+	session.Values["oidcAt"] = time.Now().Unix()
+	session.Save(r, w)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback handles GET /login/oidc/callback
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, _ := store.Get(r, "session")
+	flow := oidc.Flow{}
+	flow.State, _ = session.Values["oidcState"].(string)
+	flow.Nonce, _ = session.Values["oidcNonce"].(string)
+	flow.Verifier, _ = session.Values["oidcVerifier"].(string)
+	at, _ := session.Values["oidcAt"].(int64)
+
+	// The flow values are single use
+	delete(session.Values, "oidcState")
+	delete(session.Values, "oidcNonce")
+	delete(session.Values, "oidcVerifier")
+	delete(session.Values, "oidcAt")
+	session.Save(r, w)
+
+	fail := func(format string, args ...any) {
+		log.Printf("SSO login failed: "+format, args...)
+		audit.Record(audit.Entry{Action: "login_failed", IP: clientIP(r), Detail: "single sign-on: " + fmt.Sprintf(format, args...)})
+		http.Redirect(w, r, "/login?error=sso", http.StatusSeeOther)
+	}
+
+	if flow.State == "" || time.Since(time.Unix(at, 0)) > oidcFlowTTL {
+		fail("no login in progress")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("state")), []byte(flow.State)) != 1 {
+		fail("state mismatch")
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		fail("issuer returned %s: %s", e, r.URL.Query().Get("error_description"))
+		return
+	}
+
+	claims, err := oidcProvider.Exchange(r.Context(), r.URL.Query().Get("code"), flow)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+
+	role := oidcProvider.Role(claims)
+	if role == "" {
+		fail("%s has no CMS role", oidcProvider.Username(claims))
+		return
+	}
+	user, err := userStore.LinkExternal(oidcProvider.Subject(claims), oidcProvider.Username(claims), role)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+
+	startSession(w, r, session, user.Username)
+	audit.Record(audit.Entry{Action: "login", User: user.Username, IP: clientIP(r), Detail: "single sign-on"})
+	http.Redirect(w, r, "/posts", http.StatusSeeOther)
+}
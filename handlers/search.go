@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cms/index"
+)
+
+const defaultSearchLimit = 50
+
+// searchFromRequest runs ?q= (plus optional ?tag= and ?type= filters)
+// against the content index
+func searchFromRequest(r *http.Request) (string, []index.SearchResult) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	query := index.ParseQuery(q)
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		query.Tags = append(query.Tags, tag)
+	}
+	if typeSlug := r.URL.Query().Get("type"); typeSlug != "" {
+		query.Types = append(query.Types, typeSlug)
+	}
+	if query.Empty() {
+		return q, nil
+	}
+
+	limit := defaultSearchLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	results := contentIndex.Search(query, limit)
+	for i := range results {
+		results[i].Item.TypeSlug = resultType(query, results[i].Item.Tags)
+	}
+	return q, results
+}
+
+// resultType picks the content type a result links to: the filtered type,
+// or else the item's first tag
+func resultType(query index.Query, tags []string) string {
+	if len(query.Types) > 0 {
+		return query.Types[0]
+	}
+	if len(tags) > 0 {
+		return tags[0]
+	}
+	return ""
+}
+
+// SearchPage handles GET /search?q=
+func SearchPage(w http.ResponseWriter, r *http.Request) {
+	q, results := searchFromRequest(r)
+
+	type resultView struct {
+		index.SearchResult
+		SnippetHTML template.HTML
+	}
+	var views []resultView
+	for _, res := range results {
+		// Snippets are escaped by the index; only <mark> is added
+		views = append(views, resultView{SearchResult: res, SnippetHTML: template.HTML(res.Snippet)})
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/search.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken": csrfToken(r),
+		"Query":     q,
+		"Tag":       r.URL.Query().Get("tag"),
+		"Type":      r.URL.Query().Get("type"),
+		"Results":   views,
+	})
+}
+
+// SearchAPI handles GET /api/search?q=
+func SearchAPI(w http.ResponseWriter, r *http.Request) {
+	q, results := searchFromRequest(r)
+
+	type resultJSON struct {
+		Slug     string   `json:"slug"`
+		TypeSlug string   `json:"typeSlug"`
+		Title    string   `json:"title"`
+		Excerpt  string   `json:"excerpt"`
+		Date     string   `json:"date"`
+		Tags     []string `json:"tags"`
+		Score    float64  `json:"score"`
+		Snippet  string   `json:"snippet"`
+	}
+	out := make([]resultJSON, 0, len(results))
+	for _, res := range results {
+		out = append(out, resultJSON{
+			Slug:     res.Item.Slug,
+			TypeSlug: res.Item.TypeSlug,
+			Title:    res.Item.Title,
+			Excerpt:  res.Item.Excerpt,
+			Date:     res.Item.Date,
+			Tags:     res.Item.Tags,
+			Score:    res.Score,
+			Snippet:  res.Snippet,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"query":   q,
+		"results": out,
+	})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cms/audit"
+	"cms/config"
+	"cms/users"
+)
+
+// Failed logins are counted per username and per client IP, so one IP
+// can't guess many accounts and many IPs can't guess one account
+var userLimiter, ipLimiter *users.Limiter
+
+// SetLoginLimits configures login throttling
+func SetLoginLimits(limits config.LoginLimits) {
+	cfg := users.LimiterConfig{
+		MaxFailures: limits.MaxFailures,
+		Window:      time.Duration(limits.Window),
+		Lockout:     time.Duration(limits.Lockout),
+		BaseDelay:   time.Duration(limits.BaseDelay),
+		MaxDelay:    time.Duration(limits.MaxDelay),
+	}
+	userLimiter = users.NewLimiter(cfg)
+	cfg.MaxFailures = limits.MaxIPFailures
+	ipLimiter = users.NewLimiter(cfg)
+}
+
+// limiterKey normalizes a username so case variants share one counter
+func limiterKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// loginThrottled rejects a login attempt while the username or IP has to
+// wait, and reports whether it did. An attempt that goes ahead is
+// reserved on both limiters and must end in loginFailed or
+// loginSucceeded.
+func loginThrottled(w http.ResponseWriter, r *http.Request, username, ip, retryPath string) bool {
+	now := time.Now()
+	key := limiterKey(username)
+	wait := userLimiter.Reserve(key, now)
+	if wait == 0 {
+		if wait = ipLimiter.Reserve(ip, now); wait > 0 {
+			userLimiter.Release(key)
+		}
+	}
+	if wait == 0 {
+		return false
+	}
+
+	seconds := strconv.Itoa(int(wait.Seconds()) + 1)
+	audit.Record(audit.Entry{Action: "login_blocked", User: username, IP: ip, Detail: "retry in " + seconds + "s"})
+	w.Header().Set("Retry-After", seconds)
+	http.Redirect(w, r, retryPath+"?error=locked", http.StatusSeeOther)
+	return true
+}
+
+// loginFailed counts a failed attempt and records it
+func loginFailed(username, ip, reason string) {
+	now := time.Now()
+	userLocked := userLimiter.Fail(limiterKey(username), now)
+	ipLocked := ipLimiter.Fail(ip, now)
+
+	audit.Record(audit.Entry{Action: "login_failed", User: username, IP: ip, Detail: reason})
+	if userLocked {
+		audit.Record(audit.Entry{Action: "lockout", User: username, IP: ip, Detail: "too many failures for this username"})
+	}
+	if ipLocked {
+		audit.Record(audit.Entry{Action: "lockout", User: username, IP: ip, Detail: "too many failures from this IP"})
+	}
+}
+
+// loginSucceeded clears the username's failures. The IP keeps its
+// count, so one right password doesn't reset guessing at other accounts.
+func loginSucceeded(username, ip string) {
+	userLimiter.Reset(limiterKey(username))
+	ipLimiter.Release(ip)
+}
+
+// clientIP returns the address the request came from. Behind a reverse
+// proxy (trustProxyHeaders), that is the last X-Forwarded-For entry,
+// which the proxy itself appended.
+func clientIP(r *http.Request) string {
+	if config.AppConfig.Auth.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cms/config"
+	"cms/users"
+)
+
+func TestParallelLoginBurstIsThrottled(t *testing.T) {
+	s, err := users.Open(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create("root", "password1", users.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	previousStore := userStore
+	SetUserStore(s)
+	t.Cleanup(func() { SetUserStore(previousStore) })
+	SetLoginLimits(config.LoginLimits{
+		MaxFailures:   5,
+		MaxIPFailures: 20,
+		Window:        config.Duration(15 * time.Minute),
+		Lockout:       config.Duration(15 * time.Minute),
+		BaseDelay:     config.Duration(time.Second),
+		MaxDelay:      config.Duration(time.Minute),
+	})
+
+	// Every request gets past the throttle before the first bcrypt
+	// comparison is done, unless attempts are reserved up front
+	const burst = 30
+	locations := make([]string, burst)
+	var wg sync.WaitGroup
+	for i := range burst {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", "/login", strings.NewReader("username=root&password=guess"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			Login(w, r)
+			locations[i] = w.Header().Get("Location")
+		}()
+	}
+	wg.Wait()
+
+	checked := 0
+	for _, loc := range locations {
+		switch loc {
+		case "/login?error=1":
+			checked++
+		case "/login?error=locked":
+		default:
+			t.Errorf("unexpected redirect to %q", loc)
+		}
+	}
+	if checked != 2 {
+		t.Errorf("%d of %d parallel guesses reached the password check, want 2", checked, burst)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cms/config"
+	"cms/media"
+	"cms/users"
+)
+
+// uploadRequest builds a multipart upload of files, keyed by file name,
+// as an API token would send it
+func uploadRequest(t *testing.T, files ...[2]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range files {
+		part, err := mw.CreateFormFile("image", f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f[1]))
+	}
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/api/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	ctx := context.WithValue(r.Context(), userContextKey, users.User{Username: "alice", Role: users.RoleEditor})
+	ctx = context.WithValue(ctx, tokenContextKey, users.Token{ID: "t1", Name: "ci"})
+	return r.WithContext(ctx)
+}
+
+func TestUploadImageStreamsEachFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig.Uploads = config.UploadConfig{
+		MaxSize:      1 << 20,
+		MaxFiles:     2,
+		AllowedTypes: []string{"image/png"},
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	pngData := buf.String()
+	// Far over MaxSize, but refused from its first bytes
+	pdf := "%PDF-1.7\n" + string(bytes.Repeat([]byte("x"), 2<<20))
+	stage := filepath.Join(media.StagingDir, "token-t1")
+
+	t.Run("one result per file", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		UploadImage(w, uploadRequest(t, [2]string{"a.pdf", pdf}, [2]string{"b.png", pngData}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("UploadImage = %d: %s", w.Code, w.Body)
+		}
+		var res struct {
+			URL   string `json:"url"`
+			Files []struct {
+				Name, URL, Error string
+			} `json:"files"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != 2 || res.Files[0].Error == "" || res.Files[1].URL != res.URL || res.URL == "" {
+			t.Fatalf("response = %s", w.Body)
+		}
+		if entries, _ := os.ReadDir(stage); len(entries) != 1 {
+			t.Errorf("stage holds %d files, want 1", len(entries))
+		}
+	})
+
+	t.Run("too many files stages none", func(t *testing.T) {
+		os.RemoveAll(stage)
+		w := httptest.NewRecorder()
+		UploadImage(w, uploadRequest(t, [2]string{"a.png", pngData}, [2]string{"b.png", pngData}, [2]string{"c.png", pngData}))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("UploadImage = %d, want 400", w.Code)
+		}
+		if entries, _ := os.ReadDir(stage); len(entries) != 0 {
+			t.Errorf("stage holds %d files after a refused request", len(entries))
+		}
+	})
+
+	t.Run("wrong type is refused from its head", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		UploadImage(w, uploadRequest(t, [2]string{"a.pdf", pdf}))
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("UploadImage = %d, want 415: %s", w.Code, w.Body)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"cms/audit"
+	"cms/users"
+
+	"github.com/gorilla/mux"
+)
+
+// redirectUsers goes back to the users page with a message
+func redirectUsers(w http.ResponseWriter, r *http.Request, key, message string) {
+	http.Redirect(w, r, "/users?"+key+"="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// recordAccountChange adds a change to accounts or settings to the audit log
+func recordAccountChange(r *http.Request, action, detail string) {
+	audit.Record(audit.Entry{Action: action, User: currentUser(r), IP: clientIP(r), Detail: detail})
+}
+
+// UsersPage handles GET /users
+func UsersPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := CurrentUser(r)
+
+	tmpl := template.Must(template.ParseFiles("templates/users.html"))
+	tmpl.Execute(w, map[string]any{
+		"CSRFToken": csrfToken(r),
+		"Users":     userStore.List(),
+		"Sessions":  sessionCounts(),
+		"Roles":     users.Roles,
+		"Current":   user,
+		"Error":     r.URL.Query().Get("error"),
+		"Message":   r.URL.Query().Get("ok"),
+	})
+}
+
+// CreateUser handles POST /users
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	if err := userStore.Create(username, r.FormValue("password"), r.FormValue("role")); err != nil {
+		redirectUsers(w, r, "error", err.Error())
+		return
+	}
+	recordAccountChange(r, "user_create", username+" as "+r.FormValue("role"))
+	redirectUsers(w, r, "ok", "Created "+username)
+}
+
+// UpdateUser handles POST /users/{username} - changes role and/or password
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	if role := r.FormValue("role"); role != "" {
+		if username == currentUser(r) {
+			redirectUsers(w, r, "error", "You can't change your own role")
+			return
+		}
+		if err := userStore.SetRole(username, role); err != nil {
+			redirectUsers(w, r, "error", err.Error())
+			return
+		}
+		recordAccountChange(r, "user_role", username+" is now "+role)
+	}
+
+	if password := r.FormValue("password"); password != "" {
+		if err := userStore.SetPassword(username, password); err != nil {
+			redirectUsers(w, r, "error", err.Error())
+			return
+		}
+		recordAccountChange(r, "user_password", username)
+		// Whoever knew the old password may still be logged in
+		store.RevokeUser(username, currentSessionID(r))
+	}
+
+	redirectUsers(w, r, "ok", "Updated "+username)
+}
+
+// SignOutUser handles POST /users/{username}/sessions/delete - ends all
+// of the user's sessions
+func SignOutUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	n := store.RevokeUser(username, currentSessionID(r))
+	recordAccountChange(r, "session_revoke", fmt.Sprintf("%d sessions of %s", n, username))
+	redirectUsers(w, r, "ok", fmt.Sprintf("Signed out %s from %d sessions", username, n))
+}
+
+// sessionCounts maps each username to its number of live sessions
+func sessionCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, u := range userStore.List() {
+		counts[u.Username] = len(store.List(u.Username))
+	}
+	return counts
+}
+
+// DeleteUser handles POST /users/{username}/delete
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	if username == currentUser(r) {
+		redirectUsers(w, r, "error", "You can't delete your own account")
+		return
+	}
+	if err := userStore.Delete(username); err != nil {
+		redirectUsers(w, r, "error", err.Error())
+		return
+	}
+	store.RevokeUser(username, "")
+	recordAccountChange(r, "user_delete", username)
+	redirectUsers(w, r, "ok", "Deleted "+username)
+}
@@ -0,0 +1,166 @@
+package index
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"cms/model"
+	"cms/storage"
+)
+
+// Index keeps every content item of a directory in memory so listings,
+// tag counts and lookups don't re-read the markdown files on each request
+type Index struct {
+	dir string
+
+	mu    sync.RWMutex
+	items map[string]model.Content // slug -> item, with the body in Content
+
+	// Full-text search: term -> slug -> weighted frequency
+	postings map[string]map[string]float64
+	docs     map[string]searchDoc
+}
+
+func New(dir string) *Index {
+	return &Index{
+		dir:      dir,
+		items:    make(map[string]model.Content),
+		postings: make(map[string]map[string]float64),
+		docs:     make(map[string]searchDoc),
+	}
+}
+
+// Dir returns the content directory being indexed
+func (ix *Index) Dir() string {
+	return ix.dir
+}
+
+// Reindex reloads every item from storage, replacing the whole index
+func (ix *Index) Reindex() error {
+	files, err := storage.List(ix.dir)
+	if err != nil {
+		return err
+	}
+
+	items := make(map[string]model.Content, len(files))
+	for _, f := range files {
+		if f.IsDir || !strings.HasSuffix(f.Name, ".md") {
+			continue
+		}
+		slug := strings.TrimSuffix(f.Name, ".md")
+		item, ok := ix.load(slug)
+		if ok {
+			items[slug] = item
+		}
+	}
+
+	ix.mu.Lock()
+	ix.items = items
+	ix.postings = make(map[string]map[string]float64)
+	ix.docs = make(map[string]searchDoc)
+	for slug, item := range items {
+		ix.addPostings(slug, newSearchDoc(item))
+	}
+	ix.mu.Unlock()
+
+	return nil
+}
+
+// Refresh re-reads a single item, dropping it if the file is gone
+func (ix *Index) Refresh(slug string) {
+	item, ok := ix.load(slug)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.removePostings(slug)
+	if ok {
+		ix.items[slug] = item
+		ix.addPostings(slug, newSearchDoc(item))
+	} else {
+		delete(ix.items, slug)
+	}
+}
+
+func (ix *Index) load(slug string) (model.Content, bool) {
+	path := filepath.Join(ix.dir, slug+".md")
+	if _, err := storage.Stat(path); err != nil {
+		return model.Content{}, false
+	}
+
+	item, body, err := storage.ReadContent(path)
+	if err != nil {
+		log.Printf("Failed to read %s: %v", slug+".md", err)
+		return model.Content{}, false
+	}
+	item.Content = body
+	item.Slug = slug
+
+	return item, true
+}
+
+// Get returns the item with the given slug
+func (ix *Index) Get(slug string) (model.Content, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	item, ok := ix.items[slug]
+	return item, ok
+}
+
+// All returns every item, newest first
+func (ix *Index) All() []model.Content {
+	ix.mu.RLock()
+	items := make([]model.Content, 0, len(ix.items))
+	for _, item := range ix.items {
+		items = append(items, item)
+	}
+	ix.mu.RUnlock()
+
+	sortByDate(items)
+	return items
+}
+
+// ByTag returns the items carrying tag, newest first
+func (ix *Index) ByTag(tag string) []model.Content {
+	ix.mu.RLock()
+	var items []model.Content
+	for _, item := range ix.items {
+		for _, t := range item.Tags {
+			if t == tag {
+				items = append(items, item)
+				break
+			}
+		}
+	}
+	ix.mu.RUnlock()
+
+	sortByDate(items)
+	return items
+}
+
+// TagCounts returns the number of items per tag
+func (ix *Index) TagCounts() map[string]int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, item := range ix.items {
+		for _, tag := range item.Tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+func sortByDate(items []model.Content) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Date != items[j].Date {
+			return items[i].Date > items[j].Date
+		}
+		return items[i].Slug < items[j].Slug
+	})
+}
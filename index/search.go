@@ -0,0 +1,345 @@
+package index
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"cms/model"
+)
+
+// Searchable fields and how much a match in each counts towards the score
+const (
+	fieldTitle = iota
+	fieldExcerpt
+	fieldTags
+	fieldBody
+	numFields
+)
+
+var fieldWeights = [numFields]float64{
+	fieldTitle:   5,
+	fieldExcerpt: 2,
+	fieldTags:    3,
+	fieldBody:    1,
+}
+
+// searchDoc holds the tokens of each field of one item, kept so phrase
+// queries can be checked against word positions
+type searchDoc struct {
+	fields [numFields][]string
+}
+
+// Query is a parsed search query. Terms and phrases must all match; tags
+// and types restrict results to items carrying those tags.
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+	Tags    []string
+	Types   []string
+}
+
+// SearchResult is one ranked match with an HTML snippet in which the
+// matched words are wrapped in <mark>
+type SearchResult struct {
+	Item    model.Content
+	Score   float64
+	Snippet string
+}
+
+// ParseQuery understands plain words, "quoted phrases", tag:name and
+// type:name
+func ParseQuery(q string) Query {
+	var query Query
+
+	for len(q) > 0 {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			var phrase string
+			if end < 0 {
+				phrase, q = q[1:], ""
+			} else {
+				phrase, q = q[1:end+1], q[end+2:]
+			}
+			if words := tokenize(phrase); len(words) == 1 {
+				query.Terms = append(query.Terms, words[0])
+			} else if len(words) > 1 {
+				query.Phrases = append(query.Phrases, words)
+			}
+			continue
+		}
+
+		word := q
+		if i := strings.IndexFunc(q, unicode.IsSpace); i >= 0 {
+			word, q = q[:i], q[i:]
+		} else {
+			q = ""
+		}
+
+		switch {
+		case strings.HasPrefix(word, "tag:") && len(word) > len("tag:"):
+			query.Tags = append(query.Tags, strings.TrimPrefix(word, "tag:"))
+		case strings.HasPrefix(word, "type:") && len(word) > len("type:"):
+			query.Types = append(query.Types, strings.TrimPrefix(word, "type:"))
+		default:
+			query.Terms = append(query.Terms, tokenize(word)...)
+		}
+	}
+
+	return query
+}
+
+// Empty reports whether the query has nothing to search or filter by
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.Tags) == 0 && len(q.Types) == 0
+}
+
+// tokenize lowercases text and splits it into words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newSearchDoc(item model.Content) searchDoc {
+	var doc searchDoc
+	doc.fields[fieldTitle] = tokenize(item.Title)
+	doc.fields[fieldExcerpt] = tokenize(item.Excerpt)
+	doc.fields[fieldTags] = tokenize(strings.Join(item.Tags, " "))
+	doc.fields[fieldBody] = tokenize(item.Content)
+	return doc
+}
+
+// addPostings records the weighted term frequencies of doc. Callers hold
+// ix.mu for writing.
+func (ix *Index) addPostings(slug string, doc searchDoc) {
+	ix.docs[slug] = doc
+	for field, tokens := range doc.fields {
+		for _, term := range tokens {
+			bySlug, ok := ix.postings[term]
+			if !ok {
+				bySlug = make(map[string]float64)
+				ix.postings[term] = bySlug
+			}
+			bySlug[slug] += fieldWeights[field]
+		}
+	}
+}
+
+// removePostings drops every posting of slug. Callers hold ix.mu for writing.
+func (ix *Index) removePostings(slug string) {
+	doc, ok := ix.docs[slug]
+	if !ok {
+		return
+	}
+	for _, tokens := range doc.fields {
+		for _, term := range tokens {
+			if bySlug, ok := ix.postings[term]; ok {
+				delete(bySlug, slug)
+				if len(bySlug) == 0 {
+					delete(ix.postings, term)
+				}
+			}
+		}
+	}
+	delete(ix.docs, slug)
+}
+
+// Search returns the items matching q, best matches first
+func (ix *Index) Search(q Query, limit int) []SearchResult {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := append([]string(nil), q.Terms...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase...)
+	}
+
+	scores := make(map[string]float64)
+	if len(terms) == 0 {
+		for slug := range ix.items {
+			scores[slug] = 0
+		}
+	} else {
+		for i, term := range terms {
+			bySlug := ix.postings[term]
+			idf := math.Log(1 + float64(len(ix.items))/float64(len(bySlug)+1))
+
+			next := make(map[string]float64)
+			for slug, tf := range bySlug {
+				if _, ok := scores[slug]; i == 0 || ok {
+					next[slug] = scores[slug] + tf*idf
+				}
+			}
+			scores = next
+		}
+	}
+
+	var results []SearchResult
+	for slug, score := range scores {
+		item := ix.items[slug]
+		if !hasAllTags(item.Tags, q.Tags) || !hasAllTags(item.Tags, q.Types) {
+			continue
+		}
+
+		doc := ix.docs[slug]
+		matched := true
+		for _, phrase := range q.Phrases {
+			if !doc.containsPhrase(phrase) {
+				matched = false
+				break
+			}
+			score += float64(len(phrase))
+		}
+		if !matched {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			Item:    item,
+			Score:   score,
+			Snippet: snippet(item, terms),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Item.Date > results[j].Item.Date
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (d searchDoc) containsPhrase(phrase []string) bool {
+	for _, tokens := range d.fields {
+		for i := 0; i+len(phrase) <= len(tokens); i++ {
+			match := true
+			for j, word := range phrase {
+				if tokens[i+j] != word {
+					match = false
+					break
+				}
+			}
+			if match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasAllTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+const snippetRadius = 80
+
+// snippet cuts a window of the body (or the excerpt) around the first
+// matched term and highlights every term in it
+func snippet(item model.Content, terms []string) string {
+	text := item.Content
+	start := firstMatch(text, terms)
+	if start < 0 && item.Excerpt != "" {
+		text = item.Excerpt
+		start = firstMatch(text, terms)
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	runes := []rune(text)
+	begin := max(0, start-snippetRadius)
+	end := min(len(runes), start+snippetRadius)
+	window := strings.Join(strings.Fields(string(runes[begin:end])), " ")
+
+	prefix, suffix := "", ""
+	if begin > 0 {
+		prefix = "…"
+	}
+	if end < len(runes) {
+		suffix = "…"
+	}
+
+	return prefix + highlight(window, terms) + suffix
+}
+
+// firstMatch returns the rune offset of the first whole-word match of any
+// term in text, or -1
+func firstMatch(text string, terms []string) int {
+	for _, w := range words(text) {
+		lower := strings.ToLower(w.text)
+		for _, term := range terms {
+			if lower == term {
+				return w.start
+			}
+		}
+	}
+	return -1
+}
+
+// highlight HTML-escapes text and wraps words matching a term in <mark>
+func highlight(text string, terms []string) string {
+	runes := []rune(text)
+	var b strings.Builder
+	pos := 0
+	for _, w := range words(text) {
+		lower := strings.ToLower(w.text)
+		for _, term := range terms {
+			if lower == term {
+				b.WriteString(html.EscapeString(string(runes[pos:w.start])))
+				b.WriteString("<mark>" + html.EscapeString(w.text) + "</mark>")
+				pos = w.start + len([]rune(w.text))
+				break
+			}
+		}
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:])))
+	return b.String()
+}
+
+type word struct {
+	text  string
+	start int // rune offset
+}
+
+func words(text string) []word {
+	var out []word
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+			j++
+		}
+		out = append(out, word{text: string(runes[i:j]), start: i})
+		i = j
+	}
+	return out
+}
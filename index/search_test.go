@@ -0,0 +1,146 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cms/model"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want Query
+	}{
+		{"", Query{}},
+		{"Go  Channels", Query{Terms: []string{"go", "channels"}}},
+		{`go "Quick  Fox" tag:news type:posts`, Query{
+			Terms:   []string{"go"},
+			Phrases: [][]string{{"quick", "fox"}},
+			Tags:    []string{"news"},
+			Types:   []string{"posts"},
+		}},
+		{`"single"`, Query{Terms: []string{"single"}}},
+		{`"no closing quote here`, Query{Phrases: [][]string{{"no", "closing", "quote", "here"}}}},
+		{`"" tag:a tag:b`, Query{Tags: []string{"a", "b"}}},
+		// A bare prefix is just a word
+		{"tag: type:", Query{Terms: []string{"tag", "type"}}},
+		{"C++ rocks!", Query{Terms: []string{"c", "rocks"}}},
+	}
+	for _, tt := range tests {
+		got := ParseQuery(tt.q)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+		}
+		if got.Empty() != (tt.q == "") {
+			t.Errorf("ParseQuery(%q).Empty() = %v", tt.q, got.Empty())
+		}
+	}
+}
+
+// searchIndex indexes a few items that share the word "go" in different
+// fields
+func searchIndex(t *testing.T) *Index {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		// "go" in the title and a tag
+		"tips.md": "---\ntitle: Go tips\ntags: [posts, go]\ndate: \"2026-01-01\"\n---\n\nUse channels wisely.\n",
+		// "go" twice in the body only
+		"pasta.md": "---\ntitle: Pasta\ntags: [posts, food]\ndate: \"2026-03-01\"\n---\n\nMy go-to pasta. <script>alert(1)</script> Let it go.\n",
+		// "go" in the title only
+		"board.md": "---\ntitle: Photo of a go board\nexcerpt: Black and white stones\ntags: [photos]\ndate: \"2026-02-01\"\n---\n\nTaken at the club.\n",
+		// Same body word, older, to check the date tie-break
+		"club.md": "---\ntitle: Meetup\ntags: [photos]\ndate: \"2025-01-01\"\n---\n\nTaken at the club.\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := New(dir)
+	if err := ix.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func TestSearch(t *testing.T) {
+	ix := searchIndex(t)
+
+	tests := []struct {
+		name string
+		q    string
+		want []string // slugs, in order
+	}{
+		// Title (5) and tag (3) beat title alone, which beats two body hits
+		{"field weights", "go", []string{"tips", "board", "pasta"}},
+		{"every term must match", "go pasta", []string{"pasta"}},
+		{"no match for one term", "go sushi", nil},
+		{"excerpt is searched", "stones", []string{"board"}},
+		{"phrase", `"go board"`, []string{"board"}},
+		{"phrase words out of order", `"board go"`, nil},
+		{"phrase across punctuation", `"go to pasta"`, []string{"pasta"}},
+		{"tag filter", "go tag:food", []string{"pasta"}},
+		{"tag filter alone", "tag:go", []string{"tips"}},
+		{"type filter", "go type:photos", []string{"board"}},
+		{"tag and type together", "tag:go type:photos", nil},
+		{"equal scores newest first", "club", []string{"board", "club"}},
+		{"case insensitive", "CHANNELS", []string{"tips"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range ix.Search(ParseQuery(tt.q), 0) {
+				got = append(got, r.Item.Slug)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+
+	if got := ix.Search(ParseQuery("go"), 2); len(got) != 2 || got[0].Item.Slug != "tips" {
+		t.Errorf("Search with limit 2 = %d results", len(got))
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	ix := searchIndex(t)
+
+	tests := []struct {
+		q        string
+		contains []string
+	}{
+		{"alert", []string{"&lt;script&gt;<mark>alert</mark>(1)&lt;/script&gt;"}},
+		{"script", []string{"&lt;<mark>script</mark>&gt;", "&lt;/<mark>script</mark>&gt;"}},
+		{"pasta", []string{"My go-to <mark>pasta</mark>."}},
+		// Only the excerpt has the word, so the snippet comes from it
+		{"stones", []string{"Black and white <mark>stones</mark>"}},
+	}
+	for _, tt := range tests {
+		results := ix.Search(ParseQuery(tt.q), 0)
+		if len(results) != 1 {
+			t.Fatalf("Search(%q) = %d results, want 1", tt.q, len(results))
+		}
+		snippet := results[0].Snippet
+		if strings.Contains(snippet, "<script") || strings.Contains(snippet, "</script") {
+			t.Errorf("Search(%q) snippet isn't escaped: %s", tt.q, snippet)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(snippet, want) {
+				t.Errorf("Search(%q) snippet = %s, want it to contain %s", tt.q, snippet, want)
+			}
+		}
+	}
+
+	// A long body is cut around the first match
+	long := strings.Repeat("filler ", 40) + "needle " + strings.Repeat("filler ", 40)
+	got := snippet(model.Content{Content: long}, []string{"needle"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>needle</mark>") {
+		t.Errorf("snippet of a long body = %s", got)
+	}
+}
@@ -0,0 +1,106 @@
+package index
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cms/storage"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch keeps the index in sync with changes made outside the CMS (git
+// pulls, hand edits). It uses filesystem notifications and falls back to
+// polling every interval when they aren't available. Call the returned
+// func to stop watching.
+func (ix *Index) Watch(interval time.Duration) func() {
+	done := make(chan struct{})
+
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(ix.dir)
+		if err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("File watching unavailable for %s, polling every %s: %v", ix.dir, interval, err)
+		go ix.poll(interval, done)
+	} else {
+		go ix.watch(watcher, done)
+	}
+
+	return func() { close(done) }
+}
+
+func (ix *Index) watch(watcher *fsnotify.Watcher, done chan struct{}) {
+	defer watcher.Close()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Base(event.Name)
+			if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".md") {
+				continue
+			}
+			ix.Refresh(strings.TrimSuffix(name, ".md"))
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error on %s: %v", ix.dir, err)
+		}
+	}
+}
+
+// poll compares modification times and sizes against the last scan and
+// refreshes the items that changed
+func (ix *Index) poll(interval time.Duration, done chan struct{}) {
+	seen := ix.snapshot()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			current := ix.snapshot()
+			for slug, entry := range current {
+				if prev, ok := seen[slug]; !ok || prev.ModTime != entry.ModTime || prev.Size != entry.Size {
+					ix.Refresh(slug)
+				}
+			}
+			for slug := range seen {
+				if _, ok := current[slug]; !ok {
+					ix.Refresh(slug)
+				}
+			}
+			seen = current
+		}
+	}
+}
+
+func (ix *Index) snapshot() map[string]storage.Entry {
+	entries := make(map[string]storage.Entry)
+
+	files, err := storage.List(ix.dir)
+	if err != nil {
+		return entries
+	}
+	for _, f := range files {
+		if f.IsDir || strings.HasPrefix(f.Name, ".") || !strings.HasSuffix(f.Name, ".md") {
+			continue
+		}
+		entries[strings.TrimSuffix(f.Name, ".md")] = f
+	}
+	return entries
+}
@@ -6,59 +6,169 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 
+	"cms/audit"
 	"cms/config"
 	"cms/handlers"
+	"cms/index"
+	"cms/media"
+	"cms/oidc"
+	"cms/publish"
+	"cms/session"
+	"cms/storage"
+	"cms/users"
 )
 
 func main() {
 	_ = godotenv.Load() // load .env file automatically
 	config.LoadConfig("config.json")
 
-	// Set up secure cookie store
+	if err := config.SetupStorage(); err != nil {
+		log.Fatalf("Failed to open git storage: %v", err)
+	}
+
+	// Signs the session cookie
 	sessionSecret := os.Getenv("SESSION_SECRET")
 	if sessionSecret == "" {
 		log.Fatal("SESSION_SECRET not set")
 	}
 
-	store := sessions.NewCookieStore([]byte(sessionSecret))
+	contentIndex := index.New(config.AppConfig.ContentDir)
+	if err := contentIndex.Reindex(); err != nil {
+		log.Printf("Failed to index %s: %v", config.AppConfig.ContentDir, err)
+	}
+	contentIndex.Watch(5 * time.Second)
+	handlers.SetIndex(contentIndex)
+	publish.StartScheduler(contentIndex, time.Minute)
+	media.SetPublicDir(config.AppConfig.PublicDir)
+	storage.SetHistoryDir(config.AppConfig.HistoryDir)
+	stagingTTL := time.Duration(config.AppConfig.Uploads.StagingTTL)
+	media.StartJanitor(stagingTTL, min(stagingTTL, time.Hour))
+
+	userStore, err := users.Open(config.AppConfig.UsersFile)
+	if err != nil {
+		log.Fatalf("Failed to load users: %v", err)
+	}
+	// First run: turn the legacy CMS_USER/CMS_PASS pair into an admin account
+	if userStore.Len() == 0 {
+		username, password := os.Getenv("CMS_USER"), os.Getenv("CMS_PASS")
+		if username == "" || password == "" {
+			log.Fatalf("No users in %s; set CMS_USER and CMS_PASS to create the first admin", config.AppConfig.UsersFile)
+		}
+		if err := userStore.Create(username, password, users.RoleAdmin); err != nil {
+			log.Fatalf("Failed to create admin user %s: %v", username, err)
+		}
+		log.Printf("Created admin user %s in %s", username, config.AppConfig.UsersFile)
+	}
+	handlers.SetUserStore(userStore)
+	handlers.SetLoginLimits(config.AppConfig.Auth.LoginLimits)
+
+	auditLog, err := audit.Open(config.AppConfig.AuditFile)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	audit.SetLog(auditLog)
+	if data, err := os.ReadFile("config.json"); err == nil {
+		audit.RecordConfig("config.json", strings.Trim(storage.ETag(data), `"`))
+	}
+
+	if oidcCfg := config.AppConfig.Auth.OIDC; oidcCfg.Enabled() {
+		if oidcCfg.ClientID == "" || oidcCfg.RedirectURL == "" {
+			log.Fatal("auth.oidc needs clientID and redirectURL")
+		}
+		handlers.SetOIDCProvider(oidc.New(oidcCfg))
+		log.Printf("Single sign-on enabled with %s", oidcCfg.Issuer)
+	}
+
+	sessionCfg := config.AppConfig.Auth.Sessions
+	store, err := session.Open(config.AppConfig.SessionsFile, []byte(sessionSecret), session.Config{
+		IdleTimeout:       time.Duration(sessionCfg.IdleTimeout),
+		AbsoluteTimeout:   time.Duration(sessionCfg.AbsoluteTimeout),
+		SecureCookie:      sessionCfg.SecureCookie,
+		TrustProxyHeaders: config.AppConfig.Auth.TrustProxyHeaders,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load sessions: %v", err)
+	}
 	handlers.SetStore(store)
 
 	r := mux.NewRouter()
 
-	// Public routes
-	r.HandleFunc("/login", handlers.LoginForm).Methods("GET")
-	r.HandleFunc("/login", handlers.Login).Methods("POST")
-	r.HandleFunc("/logout", handlers.Logout).Methods("GET")
-
-	// Static file servers
+	// Static file servers, outside CSRF so fetching a stylesheet or an
+	// image never starts a session
 	r.PathPrefix("/styles/").Handler(http.StripPrefix("/styles/", http.FileServer(http.Dir("./public/styles/"))))
-	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("../public/assets/"))))
+	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir(filepath.Join(config.AppConfig.PublicDir, "assets")))))
+
+	app := r.NewRoute().Subrouter()
+	app.Use(handlers.CSRF)
+
+	// Public routes
+	app.HandleFunc("/login", handlers.LoginForm).Methods("GET")
+	app.HandleFunc("/login", handlers.Login).Methods("POST")
+	app.HandleFunc("/login/2fa", handlers.LoginTwoFactorForm).Methods("GET")
+	app.HandleFunc("/login/2fa", handlers.LoginTwoFactor).Methods("POST")
+	app.HandleFunc("/login/oidc", handlers.OIDCLogin).Methods("GET")
+	app.HandleFunc("/login/oidc/callback", handlers.OIDCCallback).Methods("GET")
+	app.HandleFunc("/logout", handlers.Logout).Methods("POST")
 
 	// Auth-protected routes
-	protected := r.NewRoute().Subrouter()
+	protected := app.NewRoute().Subrouter()
 	protected.Use(handlers.RequireLogin)
 
 	// Dashboard
-	protected.HandleFunc("/", handlers.Dashboard).Methods("GET")
-	protected.HandleFunc("/dashboard", handlers.Dashboard).Methods("GET")
+	protected.HandleFunc("/", handlers.Allow(users.PermRead, handlers.Dashboard)).Methods("GET")
+	protected.HandleFunc("/dashboard", handlers.Allow(users.PermRead, handlers.Dashboard)).Methods("GET")
+	protected.HandleFunc("/search", handlers.Allow(users.PermRead, handlers.SearchPage)).Methods("GET")
+	protected.HandleFunc("/media", handlers.Allow(users.PermRead, handlers.MediaPage)).Methods("GET")
+	protected.HandleFunc("/media/check", handlers.Allow(users.PermManageMedia, handlers.MediaCheckPage)).Methods("GET")
+	protected.HandleFunc("/media/trash", handlers.Allow(users.PermManageMedia, handlers.TrashMedia)).Methods("POST")
+
+	// Own account, two-factor setup and API tokens, open to every role
+	// but not to API tokens (registered before /{type} so it isn't shadowed)
+	protected.HandleFunc("/account", handlers.RequireSession(handlers.AccountPage)).Methods("GET")
+	protected.HandleFunc("/account/2fa/setup", handlers.RequireSession(handlers.StartTwoFactor)).Methods("POST")
+	protected.HandleFunc("/account/2fa/enable", handlers.RequireSession(handlers.EnableTwoFactor)).Methods("POST")
+	protected.HandleFunc("/account/2fa/disable", handlers.RequireSession(handlers.DisableTwoFactor)).Methods("POST")
+	protected.HandleFunc("/account/2fa/recovery", handlers.RequireSession(handlers.RegenerateRecoveryCodes)).Methods("POST")
+	protected.HandleFunc("/account/tokens", handlers.RequireSession(handlers.CreateToken)).Methods("POST")
+	protected.HandleFunc("/account/tokens/{id}/delete", handlers.RequireSession(handlers.RevokeToken)).Methods("POST")
+	protected.HandleFunc("/account/sessions/delete", handlers.RequireSession(handlers.SignOutEverywhere)).Methods("POST")
+	protected.HandleFunc("/account/sessions/{ref}/delete", handlers.RequireSession(handlers.RevokeSession)).Methods("POST")
+
+	// User management and audit log
+	protected.HandleFunc("/users", handlers.Allow(users.PermManageUsers, handlers.UsersPage)).Methods("GET")
+	protected.HandleFunc("/users", handlers.Allow(users.PermManageUsers, handlers.CreateUser)).Methods("POST")
+	protected.HandleFunc("/users/{username}", handlers.Allow(users.PermManageUsers, handlers.UpdateUser)).Methods("POST")
+	protected.HandleFunc("/users/{username}/delete", handlers.Allow(users.PermManageUsers, handlers.DeleteUser)).Methods("POST")
+	protected.HandleFunc("/users/{username}/2fa/reset", handlers.Allow(users.PermManageUsers, handlers.ResetTwoFactor)).Methods("POST")
+	protected.HandleFunc("/users/{username}/sessions/delete", handlers.Allow(users.PermManageUsers, handlers.SignOutUser)).Methods("POST")
+	protected.HandleFunc("/audit", handlers.Allow(users.PermViewAudit, handlers.AuditPage)).Methods("GET")
 
 	// Generic content type routes
-	protected.HandleFunc("/{type}/new", handlers.NewContentForm).Methods("GET")
-	protected.HandleFunc("/{type}/edit/{slug}", handlers.EditContentForm).Methods("GET")
-	protected.HandleFunc("/{type}/preview/{slug}", handlers.GetPreview).Methods("GET")
-	protected.HandleFunc("/{type}", handlers.ListContent).Methods("GET")
-
-	// Generic content API routes
-	protected.HandleFunc("/api/{type}", handlers.CreateContent).Methods("POST")
-	protected.HandleFunc("/api/{type}/{slug}", handlers.UpdateContent).Methods("PUT")
-	protected.HandleFunc("/api/{type}/{slug}", handlers.DeleteContent).Methods("DELETE")
-
-	// Shared upload endpoint
-	protected.HandleFunc("/api/upload", handlers.UploadImage).Methods("POST")
+	protected.HandleFunc("/{type}/new", handlers.Allow(users.PermCreate, handlers.NewContentForm)).Methods("GET")
+	protected.HandleFunc("/{type}/edit/{slug}", handlers.Allow(users.PermEditOwn, handlers.EditContentForm)).Methods("GET")
+	protected.HandleFunc("/{type}/preview/{slug}", handlers.Allow(users.PermRead, handlers.GetPreview)).Methods("GET")
+	protected.HandleFunc("/{type}/history/{slug}", handlers.Allow(users.PermRead, handlers.ContentHistory)).Methods("GET")
+	protected.HandleFunc("/{type}", handlers.Allow(users.PermRead, handlers.ListContent)).Methods("GET")
+
+	// Fixed API routes (registered before /api/{type} so they aren't shadowed)
+	protected.HandleFunc("/api/upload", handlers.Allow(users.PermCreate, handlers.UploadImage)).Methods("POST")
+	protected.HandleFunc("/api/reindex", handlers.Allow(users.PermReindex, handlers.Reindex)).Methods("POST")
+	protected.HandleFunc("/api/search", handlers.Allow(users.PermRead, handlers.SearchAPI)).Methods("GET")
+	protected.HandleFunc("/api/media", handlers.Allow(users.PermRead, handlers.MediaAPI)).Methods("GET")
+	protected.HandleFunc("/api/media", handlers.Allow(users.PermCreate, handlers.UploadMedia)).Methods("POST")
+
+	// Generic content API routes; ownership is checked inside the edit handlers
+	protected.HandleFunc("/api/{type}", handlers.Allow(users.PermCreate, handlers.CreateContent)).Methods("POST")
+	protected.HandleFunc("/api/{type}/{slug}", handlers.Allow(users.PermRead, handlers.GetContent)).Methods("GET")
+	protected.HandleFunc("/api/{type}/{slug}", handlers.Allow(users.PermEditOwn, handlers.UpdateContent)).Methods("PUT")
+	protected.HandleFunc("/api/{type}/{slug}", handlers.Allow(users.PermDelete, handlers.DeleteContent)).Methods("DELETE")
+	protected.HandleFunc("/api/{type}/{slug}/restore/{rev}", handlers.Allow(users.PermEditOwn, handlers.RestoreRevision)).Methods("POST")
 
 	log.Println("CMS running on http://localhost:8080")
 	http.ListenAndServe(":8080", r)
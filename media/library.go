@@ -0,0 +1,336 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"cms/model"
+	"cms/storage"
+	"cms/utils"
+)
+
+// LibraryDir is the folder in imagesDir for images uploaded straight to
+// the media library rather than with an item. Slugs can't start with "_",
+// so it never clashes with an item's folder.
+const LibraryDir = "_library"
+
+// Asset is one image in an images folder, with the versions Promote made
+// of it folded in
+type Asset struct {
+	Image
+	Path    string      `json:"-"`
+	URL     string      `json:"url"`
+	Name    string      `json:"name"`
+	Folder  string      `json:"folder"` // the item's slug, LibraryDir, or "" for loose files
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"modTime"`
+	Thumb   string      `json:"thumb"` // URL of the smallest version, for previews
+	UsedBy  []Reference `json:"usedBy"`
+}
+
+// Reference is an item that uses an asset
+type Reference struct {
+	Type  string `json:"type"`
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+// publicDir is the site's public folder, served at "/"
+var publicDir = "../public"
+
+// SetPublicDir sets the site's public folder, which image URLs are
+// derived from
+func SetPublicDir(dir string) {
+	publicDir = dir
+}
+
+// PublicPath returns the URL prefix the images in imagesDir are served
+// under: its path inside the public folder, e.g. "/assets/img" for
+// "../public/assets/img". It reports false for a folder outside the
+// public folder, whose images have no known URL.
+func PublicPath(imagesDir string) (string, bool) {
+	root, err := filepath.Abs(publicDir)
+	if err != nil {
+		return "", false
+	}
+	dir, err := filepath.Abs(imagesDir)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return "/" + filepath.ToSlash(rel), true
+}
+
+// Scan lists the images directly in each of dirs and in the folders
+// inside them, newest first. Manifests and the variants they list are
+// not assets of their own. Folders without a public path are skipped.
+func Scan(dirs []string) []Asset {
+	var assets []Asset
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		clean := filepath.Clean(dir)
+		if seen[clean] {
+			continue
+		}
+		seen[clean] = true
+
+		urlPrefix, ok := PublicPath(dir)
+		if !ok {
+			continue
+		}
+		entries, err := storage.List(dir)
+		if err != nil {
+			continue
+		}
+		assets = append(assets, scanFolder(dir, urlPrefix, "")...)
+		for _, e := range entries {
+			if e.IsDir && !strings.HasPrefix(e.Name, ".") {
+				assets = append(assets, scanFolder(dir, urlPrefix, e.Name)...)
+			}
+		}
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].ModTime.After(assets[j].ModTime)
+	})
+	return assets
+}
+
+// scanFolder lists the images in imagesDir/folder, which is served under
+// urlPrefix
+func scanFolder(imagesDir, urlPrefix, folder string) []Asset {
+	dir := filepath.Join(imagesDir, folder)
+	files, err := storage.List(dir)
+	if err != nil {
+		return nil
+	}
+
+	manifest := make(map[string]Image)
+	if data, err := storage.Get(filepath.Join(dir, ManifestName)); err == nil {
+		json.Unmarshal(data, &manifest)
+	}
+	// Versions of an image that has since been removed aren't hidden, so
+	// they show up as unused
+	present := make(map[string]bool, len(files))
+	for _, f := range files {
+		present[f.Name] = true
+	}
+	variants := make(map[string]bool)
+	for name, img := range manifest {
+		if !present[name] {
+			continue
+		}
+		for _, v := range img.Variants {
+			variants[v.File] = true
+		}
+	}
+
+	urlPrefix += "/"
+	if folder != "" {
+		urlPrefix += folder + "/"
+	}
+
+	var assets []Asset
+	for _, f := range files {
+		if f.IsDir || f.Name == ManifestName || variants[f.Name] || !isImageFile(f.Name) {
+			continue
+		}
+		a := Asset{
+			Path:    filepath.Join(dir, f.Name),
+			URL:     urlPrefix + f.Name,
+			Name:    f.Name,
+			Folder:  folder,
+			Size:    f.Size,
+			ModTime: f.ModTime,
+			UsedBy:  []Reference{},
+		}
+		if img, ok := manifest[f.Name]; ok {
+			a.Image = img
+		} else {
+			a.Image = probe(a.Path, f)
+		}
+		a.Thumb = a.URL
+		thumbWidth := a.Width
+		for _, v := range a.Variants {
+			if v.Type == a.Type && (thumbWidth == 0 || v.Width < thumbWidth) {
+				a.Thumb, thumbWidth = urlPrefix+v.File, v.Width
+			}
+		}
+		assets = append(assets, a)
+	}
+	return assets
+}
+
+// isImageFile reports whether name has an extension uploads are saved with
+func isImageFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".jpeg" {
+		return true
+	}
+	for _, e := range Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// probed caches the type and size of images that have no manifest entry,
+// which have to be read to find out, until the file changes
+var (
+	probedMu sync.Mutex
+	probed   = make(map[string]probedImage)
+)
+
+type probedImage struct {
+	entry storage.Entry
+	image Image
+}
+
+// probe reads the type and dimensions of an image Promote didn't write
+func probe(path string, f storage.Entry) Image {
+	probedMu.Lock()
+	cached, ok := probed[path]
+	probedMu.Unlock()
+	if ok && cached.entry == f {
+		return cached.image
+	}
+
+	var img Image
+	if data, err := storage.Get(path); err == nil {
+		img.Type = Sniff(data)
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			img.Width, img.Height = cfg.Width, cfg.Height
+		}
+	}
+
+	probedMu.Lock()
+	probed[path] = probedImage{entry: f, image: img}
+	probedMu.Unlock()
+	return img
+}
+
+// Link fills in each asset's UsedBy from the items whose cover image,
+// OG image or body refer to it. An item that uses one of an asset's
+// variants uses the asset. Items are listed under their TypeSlug, or
+// their first tag when it isn't set, as for items read from the index.
+func Link(assets []Asset, items []model.Content) {
+	byURL := make(map[string]*Asset)
+	for i := range assets {
+		a := &assets[i]
+		byURL[a.URL] = a
+		prefix := strings.TrimSuffix(a.URL, a.Name)
+		for _, v := range a.Variants {
+			byURL[prefix+v.File] = a
+		}
+	}
+
+	for _, item := range items {
+		ref := referenceTo(item)
+		linked := make(map[*Asset]bool)
+		for _, url := range itemURLs(item) {
+			if a, ok := byURL[url]; ok && !linked[a] {
+				linked[a] = true
+				a.UsedBy = append(a.UsedBy, ref)
+			}
+		}
+	}
+}
+
+func referenceTo(item model.Content) Reference {
+	typeSlug := item.TypeSlug
+	if typeSlug == "" && len(item.Tags) > 0 {
+		typeSlug = item.Tags[0]
+	}
+	return Reference{Type: typeSlug, Slug: item.Slug, Title: item.Title}
+}
+
+// itemURLs returns everything in an item's cover image, OG image,
+// gallery and body that could be an image URL
+func itemURLs(item model.Content) []string {
+	texts := []string{item.CoverImage, item.OGImage.URL, item.Content}
+	for _, img := range item.Gallery {
+		texts = append(texts, img.Src)
+	}
+	var urls []string
+	for _, text := range texts {
+		urls = append(urls, urlCandidates(text)...)
+	}
+	return urls
+}
+
+// Locate returns the file an image URL points at, if it is under the
+// public path of one of dirs. The file may not exist.
+func Locate(dirs []string, url string) (string, bool) {
+	for _, dir := range dirs {
+		prefix, ok := PublicPath(dir)
+		if !ok {
+			continue
+		}
+		rel, ok := strings.CutPrefix(url, prefix+"/")
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if utils.Within(dir, path) != nil {
+			return "", false
+		}
+		return path, true
+	}
+	return "", false
+}
+
+// Dimensions returns the width and height of the image at path, from its
+// folder's manifest when Promote wrote it
+func Dimensions(path string) (int, int, bool) {
+	dir, name := filepath.Split(path)
+	manifest := make(map[string]Image)
+	if data, err := storage.Get(filepath.Join(dir, ManifestName)); err == nil {
+		json.Unmarshal(data, &manifest)
+	}
+	if img, ok := manifest[name]; ok && img.Width > 0 {
+		return img.Width, img.Height, true
+	}
+	for _, img := range manifest {
+		for _, v := range img.Variants {
+			if v.File == name {
+				return v.Width, v.Height, true
+			}
+		}
+	}
+
+	f, err := storage.Stat(path)
+	if err != nil || f.IsDir {
+		return 0, 0, false
+	}
+	img := probe(path, f)
+	return img.Width, img.Height, img.Width > 0
+}
+
+// urlCandidates splits text into the runs of characters image paths are
+// made of, dropping the scheme and host from absolute URLs
+func urlCandidates(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("./_-:~", r))
+	})
+	for i, field := range fields {
+		field = strings.TrimRight(field, ".:")
+		if _, rest, ok := strings.Cut(field, "://"); ok {
+			if j := strings.Index(rest, "/"); j >= 0 {
+				field = rest[j:]
+			}
+		}
+		fields[i] = field
+	}
+	return fields
+}
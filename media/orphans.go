@@ -0,0 +1,168 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cms/model"
+	"cms/storage"
+)
+
+// Report cross-references the image folders with the items using them
+type Report struct {
+	Orphans   []Asset      `json:"orphans"`   // images in item folders that no item uses
+	Missing   []MissingRef `json:"missing"`   // image URLs items use that have no file
+	Unchecked []string     `json:"unchecked"` // images folders outside the public folder, whose URLs can't be known
+}
+
+// MissingRef is an item's reference to an image that doesn't exist
+type MissingRef struct {
+	Reference
+	URL string `json:"url"`
+}
+
+// Clean reports whether nothing was found
+func (r Report) Clean() bool {
+	return len(r.Orphans) == 0 && len(r.Missing) == 0 && len(r.Unchecked) == 0
+}
+
+// CheckAssets finds the images in dirs that no item uses, and the image
+// URLs under dirs that items use but that point at no file. Only images in
+// item folders can be unused: the ones directly in an images folder are
+// site images such as logos, which items don't refer to, and the ones in
+// the media library were uploaded to be picked later. Folders whose URLs
+// can't be derived are listed as unchecked instead.
+func CheckAssets(dirs []string, items []model.Content) Report {
+	report := Report{Orphans: []Asset{}, Missing: []MissingRef{}, Unchecked: []string{}}
+	for _, dir := range dirs {
+		if _, ok := PublicPath(dir); !ok && !slices.Contains(report.Unchecked, dir) {
+			report.Unchecked = append(report.Unchecked, dir)
+		}
+	}
+
+	assets := Scan(dirs)
+	Link(assets, items)
+	for _, a := range assets {
+		if len(a.UsedBy) == 0 && a.Folder != "" && a.Folder != LibraryDir {
+			report.Orphans = append(report.Orphans, a)
+		}
+	}
+
+	for _, item := range items {
+		seen := make(map[string]bool)
+		for _, url := range itemURLs(item) {
+			if seen[url] || !isImageFile(url) {
+				continue
+			}
+			seen[url] = true
+			path, ok := Locate(dirs, url)
+			if !ok {
+				continue
+			}
+			if _, err := storage.Stat(path); err != nil {
+				report.Missing = append(report.Missing, MissingRef{Reference: referenceTo(item), URL: url})
+			}
+		}
+	}
+	return report
+}
+
+// Trash moves assets, and the versions made of them, into a folder under
+// trashDir named for now, and drops them from their manifests. Files keep
+// their absolute path inside that folder so they can be put back by hand.
+// Trash returns the original paths it moved or changed.
+func Trash(assets []Asset, trashDir string, now time.Time) ([]string, error) {
+	batch := filepath.Join(trashDir, now.Format("20060102-150405"))
+
+	var changed []string
+	var errs []error
+	for _, a := range assets {
+		// Only images in item folders found by Scan can be unused
+		if a.URL == "" || a.Folder == "" || a.Folder == LibraryDir {
+			errs = append(errs, fmt.Errorf("not moving %s: only unused images in item folders can be trashed", a.Path))
+			continue
+		}
+		dir := filepath.Dir(a.Path)
+		files := []string{a.Path}
+		for _, v := range a.Variants {
+			files = append(files, filepath.Join(dir, v.File))
+		}
+
+		for i, path := range files {
+			err := moveToTrash(path, batch)
+			if err != nil && i == 0 {
+				// The image stays, so its versions and manifest entry do too
+				errs = append(errs, err)
+				break
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			changed = append(changed, path)
+		}
+		if !slices.Contains(changed, a.Path) {
+			continue
+		}
+
+		manifest, err := removeFromManifest(dir, a.Name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if manifest != "" {
+			changed = append(changed, manifest)
+		}
+
+		// An item folder left with nothing in it goes too
+		if a.Folder != "" {
+			if rest, err := storage.List(dir); err == nil && len(rest) == 0 {
+				storage.Delete(dir)
+			}
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// moveToTrash moves the file at path into batch, under its absolute path
+func moveToTrash(path, batch string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(batch, strings.TrimPrefix(abs, filepath.VolumeName(abs)))
+	return storage.Move(path, dst)
+}
+
+// removeFromManifest drops name from dir's manifest, deleting the
+// manifest once it is empty. It returns the manifest's path if it changed.
+func removeFromManifest(dir, name string) (string, error) {
+	path := filepath.Join(dir, ManifestName)
+	unlock := storage.Lock(path)
+	defer unlock()
+
+	data, err := storage.Get(path)
+	if err != nil {
+		return "", nil
+	}
+	manifest := make(map[string]Image)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil
+	}
+	if _, ok := manifest[name]; !ok {
+		return "", nil
+	}
+	delete(manifest, name)
+
+	if len(manifest) == 0 {
+		return path, storage.Delete(path)
+	}
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, storage.Put(path, data)
+}
@@ -0,0 +1,108 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"cms/model"
+)
+
+func TestPublicPath(t *testing.T) {
+	public := t.TempDir()
+	SetPublicDir(public)
+	t.Cleanup(func() { SetPublicDir("../public") })
+
+	tests := []struct {
+		dir  string
+		want string // "" means no public path
+	}{
+		{filepath.Join(public, "assets", "img"), "/assets/img"},
+		{filepath.Join(public, "assets", "img") + "/", "/assets/img"},
+		{filepath.Join(public, "photos"), "/photos"},
+		{public, ""},
+		{filepath.Dir(public), ""},
+		{filepath.Join(public, "..", "img"), ""},
+		{public + "-other/img", ""},
+	}
+	for _, tt := range tests {
+		got, ok := PublicPath(tt.dir)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("PublicPath(%q) = %q, %v; want %q", tt.dir, got, ok, tt.want)
+		}
+	}
+}
+
+func writeImage(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Size and type come from the manifest or a probe; the report
+	// doesn't need real image data
+	if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAssets(t *testing.T) {
+	public := t.TempDir()
+	SetPublicDir(public)
+	t.Cleanup(func() { SetPublicDir("../public") })
+
+	images := filepath.Join(public, "assets", "img")
+	outside := filepath.Join(t.TempDir(), "photo")
+	for _, f := range []string{"logo.png", "favicon.png", "trip/used.jpg", "trip/old.jpg", LibraryDir + "/spare.png"} {
+		writeImage(t, filepath.Join(images, f))
+	}
+	writeImage(t, filepath.Join(outside, "sunset", "unknown.jpg"))
+
+	items := []model.Content{{
+		Title:      "Trip",
+		Slug:       "trip",
+		Tags:       []string{"posts", "travel"},
+		CoverImage: "/assets/img/trip/used.jpg",
+		Content:    "![](/assets/img/trip/gone.jpg)",
+	}}
+	report := CheckAssets([]string{images, outside}, items)
+
+	var orphans []string
+	for _, a := range report.Orphans {
+		orphans = append(orphans, a.URL)
+	}
+	if want := []string{"/assets/img/trip/old.jpg"}; !slices.Equal(orphans, want) {
+		t.Errorf("orphans = %v, want %v (root and library images are never unused)", orphans, want)
+	}
+	// Items read from the index are listed under their first tag
+	if m := report.Missing; len(m) == 1 && m[0].Type != "posts" {
+		t.Errorf("missing reference type = %q, want posts", m[0].Type)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].URL != "/assets/img/trip/gone.jpg" {
+		t.Errorf("missing = %+v, want /assets/img/trip/gone.jpg", report.Missing)
+	}
+	if want := []string{outside}; !slices.Equal(report.Unchecked, want) {
+		t.Errorf("unchecked = %v, want %v", report.Unchecked, want)
+	}
+	if report.Clean() {
+		t.Errorf("report is clean")
+	}
+
+	// Root images can't be trashed even when passed in by hand
+	logo := Asset{Path: filepath.Join(images, "logo.png"), URL: "/assets/img/logo.png", Name: "logo.png"}
+	trash := filepath.Join(t.TempDir(), "trash")
+	if _, err := Trash([]Asset{logo}, trash, time.Now()); err == nil {
+		t.Errorf("Trash moved a root image")
+	}
+	if _, err := os.Stat(logo.Path); err != nil {
+		t.Errorf("logo.png is gone: %v", err)
+	}
+
+	if _, err := Trash(report.Orphans, trash, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(images, "trip", "old.jpg")); !os.IsNotExist(err) {
+		t.Errorf("old.jpg wasn't moved: %v", err)
+	}
+}
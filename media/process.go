@@ -0,0 +1,213 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"cms/config"
+	"cms/storage"
+
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/draw"
+)
+
+// ManifestName is the file, next to an item's images, that lists every
+// image with its size and generated versions so the site can build srcsets
+const ManifestName = "manifest.json"
+
+// Image describes one promoted image in the manifest
+type Image struct {
+	Width    int       `json:"width,omitempty"`
+	Height   int       `json:"height,omitempty"`
+	Type     string    `json:"type"`
+	Variants []Variant `json:"variants,omitempty"`
+}
+
+// Variant is a resized or WebP version of an image, in the same folder
+type Variant struct {
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Type   string `json:"type"`
+}
+
+// Promote moves the staged upload at src into dir, keeping its file name,
+// processing it on the way (see Save). It returns every path it wrote.
+func Promote(src, dir string, s config.ImageSettings) ([]string, error) {
+	data, err := storage.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	written, err := Save(data, filepath.Base(src), dir, s)
+	if err != nil {
+		return written, err
+	}
+	storage.Delete(src)
+	return written, nil
+}
+
+// Save writes an uploaded image into dir as name and records it in dir's
+// manifest. JPEGs and PNGs are re-encoded on the way, which drops EXIF
+// (including GPS) and other metadata: they are turned upright, scaled
+// down to MaxWidth, and written again at each smaller width in Widths,
+// each with a WebP version. WebPs lose their EXIF and XMP chunks; GIFs and SVGs
+// are written unchanged. Save returns every path it wrote.
+func Save(data []byte, name, dir string, s config.ImageSettings) ([]string, error) {
+	mimeType := Sniff(data)
+
+	var written []string
+	put := func(file string, data []byte) error {
+		path := filepath.Join(dir, file)
+		if err := storage.Put(path, data); err != nil {
+			return err
+		}
+		written = append(written, path)
+		return nil
+	}
+
+	var entry Image
+	var err error
+	switch mimeType {
+	case "image/jpeg", "image/png":
+		entry, err = processImage(data, name, mimeType, s, put)
+	case "image/webp":
+		entry = Image{Type: mimeType}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			entry.Width, entry.Height = cfg.Width, cfg.Height
+		}
+		err = put(name, stripWebPMetadata(data))
+	default:
+		entry = Image{Type: mimeType}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			entry.Width, entry.Height = cfg.Width, cfg.Height
+		}
+		err = put(name, data)
+	}
+	if err != nil {
+		return written, fmt.Errorf("failed to process %s: %w", name, err)
+	}
+
+	manifest, err := updateManifest(dir, name, entry)
+	if err != nil {
+		return written, err
+	}
+	return append(written, manifest), nil
+}
+
+// processImage writes the cleaned original and its variants through put
+func processImage(data []byte, name, mimeType string, s config.ImageSettings, put func(string, []byte) error) (Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, err
+	}
+	if mimeType == "image/jpeg" {
+		img = orient(img, exifOrientation(data))
+	}
+	if s.MaxWidth > 0 && img.Bounds().Dx() > s.MaxWidth {
+		img = resize(img, s.MaxWidth)
+	}
+
+	encode := func(img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		var err error
+		if mimeType == "image/jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: s.Quality})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		return buf.Bytes(), err
+	}
+
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	ext := filepath.Ext(name)
+	entry := Image{Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), Type: mimeType}
+
+	// webpOptions encodes photos lossily at the JPEG quality, and PNGs,
+	// which are usually screenshots and graphics, losslessly
+	webpOptions := webp.Options{Quality: s.Quality, Method: webp.DefaultMethod}
+	if mimeType == "image/png" {
+		webpOptions = webp.Options{Lossless: true, Method: webp.DefaultMethod}
+	}
+
+	// write saves one size of the image and its WebP version
+	write := func(img image.Image, file, webpFile string) error {
+		encoded, err := encode(img)
+		if err != nil {
+			return err
+		}
+		if err := put(file, encoded); err != nil {
+			return err
+		}
+		if !s.WantWebP() {
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, img, webpOptions); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", webpFile, err)
+		}
+		if err := put(webpFile, buf.Bytes()); err != nil {
+			return err
+		}
+		entry.Variants = append(entry.Variants, Variant{
+			File: webpFile, Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), Type: "image/webp",
+		})
+		return nil
+	}
+
+	if err := write(img, name, base+".webp"); err != nil {
+		return Image{}, err
+	}
+
+	widths := slices.Clone(s.Widths)
+	slices.Sort(widths)
+	for _, w := range slices.Compact(widths) {
+		if w <= 0 || w >= entry.Width {
+			continue
+		}
+		small := resize(img, w)
+		file := fmt.Sprintf("%s-%d%s", base, w, ext)
+		entry.Variants = append(entry.Variants, Variant{
+			File: file, Width: w, Height: small.Bounds().Dy(), Type: mimeType,
+		})
+		if err := write(small, file, fmt.Sprintf("%s-%d.webp", base, w)); err != nil {
+			return Image{}, err
+		}
+	}
+	return entry, nil
+}
+
+// resize scales img to width, keeping its aspect ratio
+func resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// updateManifest records entry under name in dir's manifest and returns
+// the manifest's path
+func updateManifest(dir, name string, entry Image) (string, error) {
+	path := filepath.Join(dir, ManifestName)
+	unlock := storage.Lock(path)
+	defer unlock()
+
+	manifest := make(map[string]Image)
+	if data, err := storage.Get(path); err == nil {
+		json.Unmarshal(data, &manifest)
+	}
+	manifest[name] = entry
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, storage.Put(path, data)
+}
@@ -0,0 +1,155 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cms/config"
+)
+
+// testImage returns a w x h image with enough detail that it doesn't
+// compress to nothing
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x * 7), uint8(y * 13), uint8((x*y + x) % 251), 255})
+		}
+	}
+	return img
+}
+
+func encodeTest(t *testing.T, encode func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encode(&buf, testImage(1600, 900)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readManifest(t *testing.T, dir string) map[string]Image {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := make(map[string]Image)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+// webpKind returns the WebP bitstream in a file: "VP8 " for lossy,
+// "VP8L" for lossless
+func webpKind(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 16 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("%s is not a WebP file", path)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("%s doesn't decode: %v", path, err)
+	}
+	return string(data[12:16])
+}
+
+func TestSaveVariants(t *testing.T) {
+	settings := config.ImageSettings{MaxWidth: 1200, Widths: []int{640, 2000}, Quality: 80}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		files    []string // written besides the manifest
+		variants []Variant
+		webp     string // bitstream of the WebP versions
+	}{
+		{
+			name:  "photo.jpg",
+			data:  encodeTest(t, func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }),
+			files: []string{"photo.jpg", "photo.webp", "photo-640.jpg", "photo-640.webp"},
+			variants: []Variant{
+				{File: "photo.webp", Width: 1200, Height: 675, Type: "image/webp"},
+				{File: "photo-640.jpg", Width: 640, Height: 360, Type: "image/jpeg"},
+				{File: "photo-640.webp", Width: 640, Height: 360, Type: "image/webp"},
+			},
+			webp: "VP8 ",
+		},
+		{
+			name:  "chart.png",
+			data:  encodeTest(t, func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }),
+			files: []string{"chart.png", "chart.webp", "chart-640.png", "chart-640.webp"},
+			variants: []Variant{
+				{File: "chart.webp", Width: 1200, Height: 675, Type: "image/webp"},
+				{File: "chart-640.png", Width: 640, Height: 360, Type: "image/png"},
+				{File: "chart-640.webp", Width: 640, Height: 360, Type: "image/webp"},
+			},
+			webp: "VP8L",
+		},
+		{
+			name:  "anim.gif",
+			data:  encodeTest(t, func(b *bytes.Buffer, img image.Image) error { return gif.Encode(b, img, nil) }),
+			files: []string{"anim.gif"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			written, err := Save(tt.data, tt.name, dir, settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for _, f := range append(tt.files, ManifestName) {
+				want = append(want, filepath.Join(dir, f))
+			}
+			slices.Sort(want)
+			slices.Sort(written)
+			if !slices.Equal(written, want) {
+				t.Errorf("written = %v, want %v", written, want)
+			}
+
+			entry := readManifest(t, dir)[tt.name]
+			if !slices.Equal(entry.Variants, tt.variants) {
+				t.Errorf("variants = %+v, want %+v", entry.Variants, tt.variants)
+			}
+			for _, v := range entry.Variants {
+				if v.Type == "image/webp" {
+					if kind := webpKind(t, filepath.Join(dir, v.File)); kind != tt.webp {
+						t.Errorf("%s is %q, want %q", v.File, kind, tt.webp)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSaveWithoutWebP(t *testing.T) {
+	off := false
+	settings := config.ImageSettings{Widths: []int{640}, Quality: 80, WebP: &off}
+	data := encodeTest(t, func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) })
+
+	dir := t.TempDir()
+	if _, err := Save(data, "photo.jpg", dir, settings); err != nil {
+		t.Fatal(err)
+	}
+	want := []Variant{{File: "photo-640.jpg", Width: 640, Height: 360, Type: "image/jpeg"}}
+	if got := readManifest(t, dir)["photo.jpg"].Variants; !slices.Equal(got, want) {
+		t.Errorf("variants = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package media
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"cms/storage"
+	"cms/utils"
+)
+
+// StagingDir holds uploads until the item that uses them is saved. Each
+// session (or API token) gets its own sub-folder, its stage, so editors
+// never see or remove each other's uploads.
+const (
+	StagingDir = "./public/tmp-preview"
+	StagingURL = "/tmp-preview/"
+)
+
+// StagedURLPattern matches staged upload URLs in frontmatter and bodies
+var StagedURLPattern = regexp.MustCompile(`/tmp-preview/([A-Za-z0-9][A-Za-z0-9._-]*)/([A-Za-z0-9][A-Za-z0-9._-]*)`)
+
+// NewStage returns a fresh, unguessable stage name
+func NewStage() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StagedPath returns where a staged upload is stored
+func StagedPath(stage, name string) (string, error) {
+	dir, err := utils.SafeJoin(StagingDir, stage)
+	if err != nil {
+		return "", err
+	}
+	return utils.SafeJoin(dir, name)
+}
+
+// StagedURL returns the public URL of a staged upload
+func StagedURL(stage, name string) string {
+	return StagingURL + stage + "/" + name
+}
+
+// ParseStagedURL splits a URL returned by StagedURL back into its stage
+// and file name
+func ParseStagedURL(url string) (stage, name string, ok bool) {
+	m := StagedURLPattern.FindStringSubmatch(url)
+	if m == nil || m[0] != url {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// ClearStage removes a stage and everything in it, e.g. at logout
+func ClearStage(stage string) {
+	dir, err := utils.SafeJoin(StagingDir, stage)
+	if err != nil {
+		return
+	}
+	storage.Delete(dir)
+}
+
+// StartJanitor sweeps staged uploads older than ttl every interval until
+// the returned stop function is called
+func StartJanitor(ttl, interval time.Duration) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		Sweep(ttl, time.Now())
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				Sweep(ttl, now)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Sweep deletes staged uploads last written before now-ttl, and stages
+// left empty, and returns how many files it deleted
+func Sweep(ttl time.Duration, now time.Time) int {
+	stages, err := storage.List(StagingDir)
+	if err != nil {
+		return 0
+	}
+
+	cutoff := now.Add(-ttl)
+	swept := 0
+	for _, stage := range stages {
+		path := filepath.Join(StagingDir, stage.Name)
+		// Loose files from before uploads were staged per session
+		if !stage.IsDir {
+			if stage.ModTime.Before(cutoff) && storage.Delete(path) == nil {
+				swept++
+			}
+			continue
+		}
+
+		files, err := storage.List(path)
+		if err != nil {
+			continue
+		}
+		// A stage is only removed once it has sat empty for ttl, so an
+		// upload racing with the sweep never loses its folder
+		if len(files) == 0 && stage.ModTime.Before(cutoff) {
+			storage.Delete(path)
+			continue
+		}
+		for _, f := range files {
+			if f.ModTime.Before(cutoff) && storage.Delete(filepath.Join(path, f.Name)) == nil {
+				swept++
+			}
+		}
+	}
+
+	if swept > 0 {
+		log.Printf("Swept %d expired uploads from %s", swept, StagingDir)
+	}
+	return swept
+}
@@ -0,0 +1,92 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSweepRemovesOnlyExpiredUploads(t *testing.T) {
+	t.Chdir(t.TempDir())
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	ttl := 24 * time.Hour
+	old, fresh := now.Add(-ttl-time.Minute), now.Add(-time.Hour)
+
+	// Path relative to StagingDir, and when it was last written
+	files := []struct {
+		path    string
+		modTime time.Time
+		kept    bool
+	}{
+		{"busy/old.png", old, false},
+		{"busy/new.png", fresh, true},
+		{"stale/a.png", old, false},
+		{"stale/b.png", old, false},
+		{"legacy-old.png", old, false}, // loose, from before stages
+		{"legacy-new.png", fresh, true},
+	}
+	for _, f := range files {
+		path := filepath.Join(StagingDir, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, f.modTime, f.modTime)
+	}
+	// Stages that are already empty: one long enough to go, one just
+	// created by an upload that is still being written
+	for name, modTime := range map[string]time.Time{"empty-old": old, "empty-new": fresh} {
+		dir := filepath.Join(StagingDir, name)
+		os.MkdirAll(dir, 0o755)
+		os.Chtimes(dir, modTime, modTime)
+	}
+	for _, dir := range []string{"busy", "stale"} {
+		os.Chtimes(filepath.Join(StagingDir, dir), fresh, fresh)
+	}
+
+	if swept := Sweep(ttl, now); swept != 4 {
+		t.Errorf("Sweep = %d, want 4", swept)
+	}
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(StagingDir, f.path))
+		if kept := err == nil; kept != f.kept {
+			t.Errorf("%s kept = %v, want %v", f.path, kept, f.kept)
+		}
+	}
+	for name, kept := range map[string]bool{"busy": true, "stale": true, "empty-old": false, "empty-new": true} {
+		_, err := os.Stat(filepath.Join(StagingDir, name))
+		if (err == nil) != kept {
+			t.Errorf("stage %s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestJanitorSweepsOnStart(t *testing.T) {
+	t.Chdir(t.TempDir())
+	path := filepath.Join(StagingDir, "stage", "old.png")
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	os.WriteFile(path, []byte("x"), 0o644)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, old, old)
+	keep := filepath.Join(StagingDir, "stage", "new.png")
+	os.WriteFile(keep, []byte("x"), 0o644)
+
+	stop := StartJanitor(time.Hour, time.Hour)
+	defer stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the janitor didn't sweep when it started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("the janitor removed a fresh upload: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package media
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// maxPixels caps the decoded size of an upload, so a small file that
+// claims huge dimensions can't exhaust memory
+const maxPixels = 50_000_000
+
+const svgType = "image/svg+xml"
+
+var (
+	ErrNotAllowed    = errors.New("file type not allowed")
+	ErrCorrupt       = errors.New("file is not a valid image")
+	ErrTooManyPixels = errors.New("image dimensions are too large")
+	ErrUnsafeSVG     = errors.New("SVG contains scripts or external content")
+)
+
+// Extensions maps each type uploads may have to the extension they are
+// saved with. The uploaded file name is never used.
+var Extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	svgType:      ".svg",
+}
+
+// decoderFormats maps MIME types to the names image.Decode reports
+var decoderFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Check sniffs data's type from its contents and makes sure it is one of
+// allowed and really is an image of that type. It returns the type.
+func Check(data []byte, allowed []string) (string, error) {
+	mimeType := Sniff(data)
+	if !slices.Contains(allowed, mimeType) || Extensions[mimeType] == "" {
+		return mimeType, fmt.Errorf("%w: %s", ErrNotAllowed, mimeType)
+	}
+
+	if mimeType == svgType {
+		return mimeType, checkSVG(data)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != decoderFormats[mimeType] {
+		return mimeType, ErrCorrupt
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return mimeType, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, cfg.Width, cfg.Height)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return mimeType, ErrCorrupt
+	}
+	return mimeType, nil
+}
+
+// SniffLen is how many leading bytes Sniff looks at for anything but SVG
+const SniffLen = 512
+
+// CheckHead refuses a file from its first SniffLen bytes when they already
+// show that its type isn't allowed, so a large upload of the wrong type is
+// turned away before the rest of it is read. XML and text heads pass, as
+// an SVG's root element may come later; Check has the final say once the
+// whole file is in.
+func CheckHead(head []byte, allowed []string) (string, error) {
+	mimeType := Sniff(head)
+	switch {
+	case mimeType == "text/xml" || mimeType == "text/plain":
+	case !slices.Contains(allowed, mimeType) || Extensions[mimeType] == "":
+		return mimeType, fmt.Errorf("%w: %s", ErrNotAllowed, mimeType)
+	}
+	return mimeType, nil
+}
+
+// Sniff returns the MIME type of data judged by its contents alone
+func Sniff(data []byte) string {
+	mimeType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if (mimeType == "text/xml" || mimeType == "text/plain") && isSVG(data) {
+		return svgType
+	}
+	return mimeType
+}
+
+// isSVG reports whether the document's root element is <svg>
+func isSVG(data []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return strings.EqualFold(start.Name.Local, "svg")
+		}
+	}
+}
+
+// Elements that can run script or embed other documents
+var unsafeSVGElements = []string{"script", "foreignobject", "iframe", "embed", "object", "handler", "listener"}
+
+// checkSVG rejects SVGs that could run script when opened directly:
+// script elements, on* event attributes, javascript: URLs (including ones
+// set by <animate>), DTDs that could define entities, and stylesheets
+// pulled in by processing instructions
+func checkSVG(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return ErrCorrupt
+		}
+
+		switch t := tok.(type) {
+		case xml.Directive:
+			return ErrUnsafeSVG
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				return ErrUnsafeSVG
+			}
+		case xml.StartElement:
+			if slices.Contains(unsafeSVGElements, strings.ToLower(t.Name.Local)) {
+				return ErrUnsafeSVG
+			}
+			for _, attr := range t.Attr {
+				if strings.HasPrefix(strings.ToLower(attr.Name.Local), "on") || scriptURL(attr.Value) {
+					return ErrUnsafeSVG
+				}
+			}
+		}
+	}
+}
+
+// scriptURL reports whether v is a javascript: or vbscript: URL, ignoring
+// the case and the whitespace browsers skip over
+func scriptURL(v string) bool {
+	v = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, v))
+	return strings.Contains(v, "javascript:") || strings.Contains(v, "vbscript:")
+}
+
+// FormatSize renders a byte count for error messages
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
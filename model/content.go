@@ -1,18 +1,95 @@
 package model
 
+import "gopkg.in/yaml.v3"
+
 // Content represents any content type with common fields
 type Content struct {
-	Title      string   `yaml:"title" json:"title"`
-	Excerpt    string   `yaml:"excerpt" json:"excerpt"`
-	CoverImage string   `yaml:"coverImage" json:"coverImage"`
-	Date       string   `yaml:"date" json:"date"`
-	OGImage    OGImage  `yaml:"ogImage" json:"ogImage"`
-	Tags       []string `yaml:"tags" json:"tags"`
-	Content    string   `yaml:"-" json:"content"`
-	Slug       string   `yaml:"-" json:"slug"`
+	Title      string         `yaml:"title" json:"title"`
+	Excerpt    string         `yaml:"excerpt" json:"excerpt"`
+	CoverImage string         `yaml:"coverImage" json:"coverImage"`
+	Date       string         `yaml:"date" json:"date"`
+	OGImage    OGImage        `yaml:"ogImage" json:"ogImage"`
+	Gallery    []GalleryImage `yaml:"gallery,omitempty" json:"gallery,omitempty"`
+	Tags       []string       `yaml:"tags" json:"tags"`
+	Status     string         `yaml:"status,omitempty" json:"status,omitempty"`
+	PublishAt  string         `yaml:"publishAt,omitempty" json:"publishAt,omitempty"`
+	CreatedBy  string         `yaml:"createdBy,omitempty" json:"createdBy,omitempty"`
+	Content    string         `yaml:"-" json:"content"`
+	Slug       string         `yaml:"-" json:"slug"`
 
 	// Content type metadata (not in frontmatter)
 	TypeSlug string `yaml:"-" json:"typeSlug"`
+
+	// Extra holds frontmatter fields the CMS doesn't model, e.g. author,
+	// draft, series or canonical, so they survive a save
+	Extra map[string]any `yaml:",inline" json:"extra,omitempty"`
+
+	// ETag is a hash of the file as it was read, used for optimistic concurrency
+	ETag string `yaml:"-" json:"etag,omitempty"`
+}
+
+// GalleryImage is one image of an item's gallery. Width and height are
+// filled in by the CMS when it saves the item.
+type GalleryImage struct {
+	Src     string `yaml:"src" json:"src"`
+	Alt     string `yaml:"alt,omitempty" json:"alt,omitempty"`
+	Caption string `yaml:"caption,omitempty" json:"caption,omitempty"`
+	Width   int    `yaml:"width,omitempty" json:"width,omitempty"`
+	Height  int    `yaml:"height,omitempty" json:"height,omitempty"`
+}
+
+// UnmarshalYAML also accepts a bare URL, so hand-written galleries can be
+// a plain list of images
+func (g *GalleryImage) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*g = GalleryImage{Src: value.Value}
+		return nil
+	}
+	type plain GalleryImage
+	return value.Decode((*plain)(g))
+}
+
+// Publication statuses. Items without a status are treated as published.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+// Statuses lists every valid status in workflow order
+var Statuses = []string{StatusDraft, StatusScheduled, StatusPublished, StatusArchived}
+
+// ValidStatus reports whether s is empty or one of Statuses
+func ValidStatus(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, status := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveStatus returns the status. Items written before statuses
+// existed are drafts if the site's draft key says so, else published.
+func (c Content) EffectiveStatus() string {
+	if c.Status == "" {
+		if c.Draft() {
+			return StatusDraft
+		}
+		return StatusPublished
+	}
+	return c.Status
+}
+
+// Draft reports the draft frontmatter key the site reads. The CMS keeps
+// it in step with Status when it saves an item.
+func (c Content) Draft() bool {
+	draft, _ := c.Extra["draft"].(bool)
+	return draft
 }
 
 type OGImage struct {
@@ -0,0 +1,239 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"cms/config"
+	"cms/users"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// signingAlgs are the ID token algorithms we accept. go-jose also checks
+// that an EC key's curve matches the algorithm's.
+var signingAlgs = []string{
+	gooidc.RS256, gooidc.RS384, gooidc.RS512,
+	gooidc.PS256, gooidc.PS384, gooidc.PS512,
+	gooidc.ES256, gooidc.ES384, gooidc.ES512,
+}
+
+// Claims are the decoded claims of a verified ID token
+type Claims map[string]any
+
+// String returns a string claim, or "" if it is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim that may be a single string or a list of them
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Provider talks to one OpenID Connect issuer
+type Provider struct {
+	cfg config.OIDCConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// New returns a provider for cfg. Discovery happens on first use, so the
+// CMS still starts when the issuer is down.
+func New(cfg config.OIDCConfig) *Provider {
+	return &Provider{cfg: cfg}
+}
+
+// Config returns the provider's configuration
+func (p *Provider) Config() config.OIDCConfig {
+	return p.cfg
+}
+
+// clientContext makes the oauth2 and go-oidc calls made with ctx use our
+// HTTP client
+func clientContext(ctx context.Context) context.Context {
+	return gooidc.ClientContext(ctx, httpClient)
+}
+
+// discover fetches the issuer's metadata and sets up the OAuth2 client
+// and ID token verifier. A failed discovery is retried on the next login.
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	// go-oidc refuses a discovery document whose issuer isn't exactly the
+	// configured one, otherwise a token from another tenant could validate.
+	// The key set keeps this context's client, not its deadline.
+	provider, err := gooidc.NewProvider(clientContext(ctx), p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	endpoint := provider.Endpoint()
+	if p.cfg.ClientSecret == "" {
+		// Public client: PKCE alone proves the code is ours
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
+	} else {
+		endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.scopes(),
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{
+		ClientID:             p.cfg.ClientID,
+		SupportedSigningAlgs: signingAlgs,
+	})
+	return p.oauth, p.verifier, nil
+}
+
+// Flow holds the per-login secrets that must survive the redirect to the
+// issuer and back
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewFlow generates fresh state, nonce and PKCE verifier values
+func NewFlow() (Flow, error) {
+	var f Flow
+	for _, v := range []*string{&f.State, &f.Nonce, &f.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Flow{}, err
+		}
+		*v = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return f, nil
+}
+
+// AuthURL returns where to send the browser to log in
+func (p *Provider) AuthURL(ctx context.Context, f Flow) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(f.State, gooidc.Nonce(f.Nonce), oauth2.S256ChallengeOption(f.Verifier)), nil
+}
+
+func (p *Provider) scopes() []string {
+	scopes := []string{gooidc.ScopeOpenID}
+	for _, s := range p.cfg.Scopes {
+		if s != gooidc.ScopeOpenID {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// Exchange trades an authorization code for tokens and returns the
+// verified ID token claims
+func (p *Provider) Exchange(ctx context.Context, code string, f Flow) (Claims, error) {
+	oauth, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = clientContext(ctx)
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(f.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	// Checks the signature, issuer, audience and expiry
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(f.Nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if aud := claims.Strings("aud"); len(aud) > 1 && claims.String("azp") != p.cfg.ClientID {
+		return nil, errors.New("ID token was issued to another party")
+	}
+	if idToken.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// Role maps the role claim to a CMS role. When several values map, the
+// most privileged role wins; when none do, DefaultRole is used, and an
+// empty result means the user may not log in.
+func (p *Provider) Role(claims Claims) string {
+	mapped := make(map[string]bool)
+	for _, value := range claims.Strings(p.cfg.RoleClaim) {
+		if role, ok := p.cfg.RoleMapping[value]; ok {
+			mapped[role] = true
+		}
+	}
+	for _, role := range users.Roles {
+		if mapped[role] {
+			return role
+		}
+	}
+	if users.ValidRole(p.cfg.DefaultRole) {
+		return p.cfg.DefaultRole
+	}
+	return ""
+}
+
+// Username picks the CMS username from the configured claim, falling back
+// to the email address and then the subject
+func (p *Provider) Username(claims Claims) string {
+	for _, name := range []string{p.cfg.UsernameClaim, "email", "sub"} {
+		if v := claims.String(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Subject identifies the user across logins, even if their username at
+// the issuer changes
+func (p *Provider) Subject(claims Claims) string {
+	return p.cfg.Issuer + " " + claims.String("sub")
+}
@@ -0,0 +1,182 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"cms/config"
+	"cms/oidc/oidctest"
+)
+
+func TestExchange(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// token returns the ID token the issuer hands out, given the
+		// claims a valid token would have
+		token    func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string
+		verifier string // sent instead of the flow's own when set
+		wantErr  string
+	}{
+		{
+			name: "valid token",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				return sign(t, is.Key, claims)
+			},
+		},
+		{
+			name: "bad aud",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				claims["aud"] = "another-client"
+				return sign(t, is.Key, claims)
+			},
+			wantErr: "expected audience",
+		},
+		{
+			name: "another party",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				claims["aud"] = []string{"cms", "another-client"}
+				claims["azp"] = "another-client"
+				return sign(t, is.Key, claims)
+			},
+			wantErr: "issued to another party",
+		},
+		{
+			name: "expired token",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				claims["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return sign(t, is.Key, claims)
+			},
+			wantErr: "expired",
+		},
+		{
+			name: "wrong nonce",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				claims["nonce"] = "replayed"
+				return sign(t, is.Key, claims)
+			},
+			wantErr: "nonce does not match",
+		},
+		{
+			name: "alg none",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				segment := func(v any) string {
+					data, _ := json.Marshal(v)
+					return base64.RawURLEncoding.EncodeToString(data)
+				}
+				return segment(map[string]string{"alg": "none", "kid": oidctest.KeyID}) + "." + segment(claims) + "."
+			},
+			wantErr: "malformed jwt",
+		},
+		{
+			name: "signed by another key",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				return sign(t, other, claims)
+			},
+			wantErr: "failed to verify signature",
+		},
+		{
+			name: "PKCE mismatch",
+			token: func(t *testing.T, is *oidctest.Issuer, claims map[string]any) string {
+				return sign(t, is.Key, claims)
+			},
+			verifier: "not-the-verifier",
+			wantErr:  "invalid_grant",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is, err := oidctest.NewIssuer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(is)
+			t.Cleanup(srv.Close)
+			is.URL = srv.URL
+
+			cfg := config.OIDCConfig{
+				Issuer:      is.URL,
+				ClientID:    "cms",
+				RedirectURL: "https://cms.example.com/login/oidc/callback",
+			}
+			p := New(cfg)
+			ctx := context.Background()
+
+			flow, err := NewFlow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := p.AuthURL(ctx, flow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := u.Query()
+			if q.Get("code_challenge_method") != "S256" || q.Get("nonce") != flow.Nonce || q.Get("state") != flow.State {
+				t.Fatalf("AuthURL = %s", authURL)
+			}
+
+			code := is.Grant(oidctest.Grant{
+				ClientID:    cfg.ClientID,
+				RedirectURI: cfg.RedirectURL,
+				Challenge:   q.Get("code_challenge"),
+				Nonce:       q.Get("nonce"),
+				IDToken: tt.token(t, is, map[string]any{
+					"iss":   is.URL,
+					"aud":   "cms",
+					"sub":   "1234",
+					"email": "alice@example.com",
+					"nonce": q.Get("nonce"),
+					"iat":   time.Now().Unix(),
+					"exp":   time.Now().Add(time.Hour).Unix(),
+				}),
+			})
+			if tt.verifier != "" {
+				flow.Verifier = tt.verifier
+			}
+
+			claims, err := p.Exchange(ctx, code, flow)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Username(claims); got != "alice@example.com" {
+				t.Errorf("Username = %q", got)
+			}
+			if got := p.Subject(claims); got != is.URL+" 1234" {
+				t.Errorf("Subject = %q", got)
+			}
+		})
+	}
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	token, err := oidctest.Sign(key, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
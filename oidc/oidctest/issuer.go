@@ -0,0 +1,184 @@
+// Package oidctest is a minimal OpenID Connect issuer for the oidc tests
+// and cmd/mockoidc. It signs whatever it is told to; never expose it.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// KeyID is the key ID of the issuer's signing key in its JWKS
+const KeyID = "k1"
+
+// Grant is what the issuer remembers about an authorization code until
+// the client redeems it
+type Grant struct {
+	ClientID    string
+	RedirectURI string
+	Challenge   string // PKCE S256 code challenge
+	Nonce       string
+
+	// Claims go into the ID token next to iss, aud, nonce, iat and exp,
+	// which the issuer fills in unless Claims already has them
+	Claims map[string]any
+	// IDToken, when set, is returned as is instead of signing Claims
+	IDToken string
+
+	expires time.Time
+}
+
+// Issuer serves discovery, JWKS and the token endpoint for the codes
+// handed out by Grant. Set URL to where it is served before the first
+// request.
+type Issuer struct {
+	URL string
+	Key *rsa.PrivateKey
+
+	mux    *http.ServeMux
+	mu     sync.Mutex
+	grants map[string]Grant
+}
+
+// NewIssuer returns an issuer with a fresh RSA signing key
+func NewIssuer() (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	is := &Issuer{Key: key, mux: http.NewServeMux(), grants: map[string]Grant{}}
+	is.mux.HandleFunc("/.well-known/openid-configuration", is.discovery)
+	is.mux.HandleFunc("/jwks", is.jwks)
+	is.mux.HandleFunc("/token", is.token)
+	return is, nil
+}
+
+func (is *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	is.mux.ServeHTTP(w, r)
+}
+
+// Grant stores g and returns the authorization code that redeems it. The
+// code is valid for a minute and only once.
+func (is *Issuer) Grant(g Grant) string {
+	code := RandomString()
+	g.expires = time.Now().Add(time.Minute)
+
+	is.mu.Lock()
+	is.grants[code] = g
+	is.mu.Unlock()
+	return code
+}
+
+// Sign returns claims as a compact JWT signed RS256 with key
+func Sign(key *rsa.PrivateKey, claims map[string]any) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": KeyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// RandomString returns 24 random bytes, base64url encoded
+func RandomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (is *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                is.URL,
+		"authorization_endpoint":                is.URL + "/authorize",
+		"token_endpoint":                        is.URL + "/token",
+		"jwks_uri":                              is.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (is *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": KeyID,
+		"n":   base64.RawURLEncoding.EncodeToString(is.Key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(is.Key.E)).Bytes()),
+	}}})
+}
+
+func (is *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	code := r.FormValue("code")
+
+	is.mu.Lock()
+	g, ok := is.grants[code]
+	delete(is.grants, code)
+	is.mu.Unlock()
+
+	clientID := r.FormValue("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+	}
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	switch {
+	case r.FormValue("grant_type") != "authorization_code" || !ok || time.Now().After(g.expires):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case clientID != g.ClientID || r.FormValue("redirect_uri") != g.RedirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.Challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	idToken := g.IDToken
+	if idToken == "" {
+		now := time.Now()
+		claims := map[string]any{
+			"iss":   is.URL,
+			"aud":   g.ClientID,
+			"nonce": g.Nonce,
+			"iat":   now.Unix(),
+			"exp":   now.Add(5 * time.Minute).Unix(),
+		}
+		for k, v := range g.Claims {
+			claims[k] = v
+		}
+		var err error
+		if idToken, err = Sign(is.Key, claims); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": RandomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,59 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"cms/config"
+	"cms/model"
+)
+
+var hookClient = &http.Client{Timeout: 10 * time.Second}
+
+// FireHooks runs every configured publish hook for item in the background.
+// typeSlug is the type it was published through, or "" for the scheduler.
+func FireHooks(item model.Content, typeSlug string) {
+	for _, hook := range config.AppConfig.PublishHooks {
+		go runHook(hook, item, typeSlug)
+	}
+}
+
+func runHook(hook config.PublishHook, item model.Content, typeSlug string) {
+	if hook.URL != "" {
+		payload, _ := json.Marshal(map[string]any{
+			"event": "publish",
+			"type":  typeSlug,
+			"tags":  item.Tags,
+			"slug":  item.Slug,
+			"title": item.Title,
+		})
+		res, err := hookClient.Post(hook.URL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("Publish hook %s failed: %v", hook.URL, err)
+		} else {
+			res.Body.Close()
+			if res.StatusCode >= 300 {
+				log.Printf("Publish hook %s returned %s", hook.URL, res.Status)
+			}
+		}
+	}
+
+	if hook.Command != "" {
+		cmd := exec.Command("sh", "-c", hook.Command)
+		cmd.Env = append(os.Environ(),
+			"CMS_TYPE="+typeSlug,
+			"CMS_TAGS="+strings.Join(item.Tags, ","),
+			"CMS_SLUG="+item.Slug,
+			"CMS_TITLE="+item.Title,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("Publish hook %q failed: %v: %s", hook.Command, err, out)
+		}
+	}
+}
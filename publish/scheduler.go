@@ -0,0 +1,124 @@
+package publish
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cms/audit"
+	"cms/index"
+	"cms/model"
+	"cms/storage"
+)
+
+// publishAtFormats are accepted for publishAt; values without a zone are
+// in server local time (datetime-local inputs send "2006-01-02T15:04")
+var publishAtFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParsePublishAt parses a publishAt frontmatter value
+func ParsePublishAt(s string) (time.Time, error) {
+	for _, layout := range publishAtFormats {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid publishAt %q", s)
+}
+
+// StartScheduler publishes scheduled items once their publishAt has passed,
+// checking every interval. Call the returned func to stop it.
+func StartScheduler(ix *index.Index, interval time.Duration) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		PublishDue(ix, time.Now())
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				PublishDue(ix, now)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// PublishDue flips every scheduled item whose publishAt is at or before now
+// to published and returns how many were published
+func PublishDue(ix *index.Index, now time.Time) int {
+	published := 0
+	for _, item := range ix.All() {
+		if item.Status != model.StatusScheduled {
+			continue
+		}
+		at, err := ParsePublishAt(item.PublishAt)
+		if err != nil || at.After(now) {
+			continue
+		}
+		if publishItem(ix, item.Slug, now) {
+			published++
+		}
+	}
+	return published
+}
+
+func publishItem(ix *index.Index, slug string, now time.Time) bool {
+	path := filepath.Join(ix.Dir(), slug+".md")
+
+	unlock := storage.Lock(path)
+	defer unlock()
+
+	// Re-read under the lock in case an editor changed it meanwhile
+	item, body, err := storage.ReadContent(path)
+	if err != nil || item.Status != model.StatusScheduled {
+		return false
+	}
+	if at, err := ParsePublishAt(item.PublishAt); err != nil || at.After(now) {
+		return false
+	}
+
+	item.Content = body
+	item.Slug = slug
+	item.Status = model.StatusPublished
+	before := item.ETag
+	if err := storage.WriteContent(path, item); err != nil {
+		log.Printf("Failed to publish %s: %v", slug, err)
+		return false
+	}
+
+	// An item belongs to every type it is tagged with, and the one it
+	// was scheduled through isn't stored, so the scheduler names none
+	if err := storage.Commit(fmt.Sprintf("Publish %s by scheduler", slug), "scheduler", path); err != nil {
+		log.Printf("Failed to commit %s: %v", slug, err)
+	}
+	ix.Refresh(slug)
+
+	after := ""
+	if data, err := storage.Get(path); err == nil {
+		after = storage.ETag(data)
+	}
+	audit.Record(audit.Entry{
+		Action: "publish",
+		User:   "scheduler",
+		Slug:   slug,
+		Before: strings.Trim(before, `"`),
+		After:  strings.Trim(after, `"`),
+	})
+
+	log.Printf("Published scheduled item %s", slug)
+	FireHooks(item, "")
+	return true
+}
@@ -0,0 +1,55 @@
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cms/index"
+	"cms/storage"
+)
+
+func TestPublishDueClearsDraft(t *testing.T) {
+	dir := t.TempDir()
+	storage.SetHistoryDir(t.TempDir())
+	t.Cleanup(func() { storage.SetHistoryDir("history") })
+
+	files := map[string]string{
+		"due.md":    "---\ntitle: Due\ntags: [travel, posts]\nstatus: scheduled\npublishAt: \"2026-05-01T09:00\"\ndraft: true\n---\n\nBody\n",
+		"later.md":  "---\ntitle: Later\ntags: [posts]\nstatus: scheduled\npublishAt: \"2026-06-01T09:00\"\ndraft: true\n---\n\nBody\n",
+		"draft.md":  "---\ntitle: Draft\ntags: [posts]\nstatus: draft\ndraft: true\n---\n\nBody\n",
+		"legacy.md": "---\ntitle: Legacy\ntags: [posts]\ndraft: true\n---\n\nBody\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := index.New(dir)
+	if err := ix.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+
+	now, _ := ParsePublishAt("2026-05-02T09:00")
+	if n := PublishDue(ix, now); n != 1 {
+		t.Fatalf("PublishDue published %d items, want 1", n)
+	}
+
+	for name, wantDraft := range map[string]bool{"due.md": false, "later.md": true, "draft.md": true, "legacy.md": true} {
+		item, _, err := storage.ReadContent(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Draft() != wantDraft {
+			t.Errorf("%s has draft %v, want %v", name, item.Draft(), wantDraft)
+		}
+	}
+	if item, _, _ := storage.ReadContent(filepath.Join(dir, "due.md")); item.Status != "published" || strings.Join(item.Tags, ",") != "travel,posts" {
+		t.Errorf("due.md = %q %v, want published with its tags unchanged", item.Status, item.Tags)
+	}
+	if n := PublishDue(ix, now.Add(time.Hour)); n != 0 {
+		t.Errorf("a second run published %d items", n)
+	}
+}
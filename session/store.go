@@ -0,0 +1,390 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"cms/storage"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Session values the store looks at. Handlers set them at login.
+const (
+	usernameKey      = "username"
+	authenticatedKey = "authenticated"
+	loginIPKey       = "loginIP"
+)
+
+// Limits on sessions that never logged in. They hold little more than a
+// CSRF token, so they stay in memory and are dropped sooner.
+const (
+	anonymousIdle = 30 * time.Minute
+	maxAnonymous  = 10000
+)
+
+// touchInterval is how stale a session's last-seen time on disk may get
+// before a request writes the file again
+const touchInterval = time.Minute
+
+// Config sets session lifetimes and cookie flags
+type Config struct {
+	IdleTimeout       time.Duration // sessions unused for this long expire
+	AbsoluteTimeout   time.Duration // sessions expire this long after login, however busy
+	SecureCookie      bool          // always mark the cookie Secure
+	TrustProxyHeaders bool          // treat X-Forwarded-Proto: https as TLS
+}
+
+// Info describes a logged-in session for the account page
+type Info struct {
+	Ref       string // stands in for the session ID, which never leaves the cookie
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// Store keeps sessions on the server. The cookie only carries a signed
+// session ID, so deleting a session here logs it out for good. Logged-in
+// sessions are saved to a JSON file and survive restarts.
+type Store struct {
+	path   string
+	cfg    Config
+	codecs []securecookie.Codec
+
+	mu       sync.Mutex
+	sessions map[string]*record
+}
+
+type record struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username,omitempty"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Data      []byte    `json:"data"` // gob-encoded session values
+
+	values map[any]any
+	saved  time.Time // LastSeen as last written to disk
+}
+
+// Open loads the sessions file at path; a missing file is an empty store.
+// secret signs the session cookie.
+func Open(path string, secret []byte, cfg Config) (*Store, error) {
+	s := &Store{
+		path:     path,
+		cfg:      cfg,
+		codecs:   securecookie.CodecsFromPairs(secret),
+		sessions: make(map[string]*record),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*record
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	now := time.Now()
+	for _, rec := range list {
+		if err := gob.NewDecoder(bytes.NewReader(rec.Data)).Decode(&rec.values); err != nil || s.expired(rec, now) {
+			continue
+		}
+		rec.saved = rec.LastSeen
+		s.sessions[rec.ID] = rec
+	}
+	return s, nil
+}
+
+// Get returns the named session, cached for the rest of the request
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named in the request's cookie, or starts an empty
+// one if there is no cookie or the session has expired or been revoked
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = s.options(r)
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.sessions[id]
+	now := time.Now()
+	if !ok || s.expired(rec, now) {
+		if ok {
+			delete(s.sessions, id)
+		}
+		return session, nil
+	}
+	rec.LastSeen = now
+	if rec.Username != "" && now.Sub(rec.saved) > touchInterval {
+		s.save()
+	}
+
+	session.ID = id
+	session.IsNew = false
+	for k, v := range rec.values {
+		session.Values[k] = v
+	}
+	return session, nil
+}
+
+// Save stores the session's values and sets the cookie. A negative
+// MaxAge deletes the session.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session.Options.MaxAge < 0 {
+		if rec, ok := s.sessions[session.ID]; ok {
+			delete(s.sessions, session.ID)
+			if rec.Username != "" {
+				s.save()
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	now := time.Now()
+	rec, ok := s.sessions[session.ID]
+	if !ok {
+		if session.ID != "" {
+			// Revoked while this request was running
+			delete(session.Values, authenticatedKey)
+			delete(session.Values, usernameKey)
+		}
+		session.ID = newID()
+		rec = &record{ID: session.ID, Created: now, UserAgent: r.UserAgent()}
+		s.sessions[session.ID] = rec
+	}
+	persisted := rec.Username != ""
+
+	rec.LastSeen = now
+	rec.values = make(map[any]any, len(session.Values))
+	for k, v := range session.Values {
+		rec.values[k] = v
+	}
+	rec.Username = ""
+	if auth, _ := rec.values[authenticatedKey].(bool); auth {
+		rec.Username, _ = rec.values[usernameKey].(string)
+	}
+
+	if rec.Username != "" || persisted {
+		if err := s.save(); err != nil {
+			return err
+		}
+	} else if !ok {
+		s.limitAnonymous(now)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Renew gives the session a new ID the next time it is saved, dropping
+// the old one. Call it at login so an ID planted before login is useless.
+func (s *Store) Renew(session *sessions.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.sessions[session.ID]; ok {
+		delete(s.sessions, session.ID)
+		if rec.Username != "" {
+			s.save()
+		}
+	}
+	session.ID = ""
+}
+
+// List returns username's live sessions, most recently used first
+func (s *Store) List(username string) []Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var list []Info
+	for _, rec := range s.sessions {
+		if rec.Username != username || s.expired(rec, now) {
+			continue
+		}
+		ip, _ := rec.values[loginIPKey].(string)
+		list = append(list, Info{
+			Ref:       Ref(rec.ID),
+			Created:   rec.Created,
+			LastSeen:  rec.LastSeen,
+			IP:        ip,
+			UserAgent: rec.UserAgent,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// Revoke ends one of username's sessions by its Ref
+func (s *Store) Revoke(username, ref string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, rec := range s.sessions {
+		if rec.Username == username && Ref(id) == ref {
+			delete(s.sessions, id)
+			s.save()
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeUser ends all of username's sessions and returns how many there
+// were. The session with ID keep, if any, is left alone.
+func (s *Store) RevokeUser(username, keep string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, rec := range s.sessions {
+		if rec.Username == username && id != keep {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	if n > 0 {
+		s.save()
+	}
+	return n
+}
+
+// Ref returns the public handle for a session ID
+func Ref(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// options returns the cookie flags for a response to r
+func (s *Store) options(r *http.Request) *sessions.Options {
+	secure := s.cfg.SecureCookie || r.TLS != nil ||
+		(s.cfg.TrustProxyHeaders && r.Header.Get("X-Forwarded-Proto") == "https")
+	return &sessions.Options{
+		Path:     "/",
+		MaxAge:   int(s.cfg.AbsoluteTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (s *Store) expired(rec *record, now time.Time) bool {
+	idle := s.cfg.IdleTimeout
+	if rec.Username == "" {
+		idle = min(idle, anonymousIdle)
+	}
+	return now.Sub(rec.LastSeen) > idle || now.Sub(rec.Created) > s.cfg.AbsoluteTimeout
+}
+
+// limitAnonymous keeps a flood of cookie-less requests from growing the
+// map forever: expired sessions go first, then the least recently used.
+// Callers hold s.mu.
+func (s *Store) limitAnonymous(now time.Time) {
+	anonymous := 0
+	for _, rec := range s.sessions {
+		if rec.Username == "" {
+			anonymous++
+		}
+	}
+	if anonymous <= maxAnonymous {
+		return
+	}
+
+	var oldest *record
+	for id, rec := range s.sessions {
+		if rec.Username != "" {
+			continue
+		}
+		if s.expired(rec, now) {
+			delete(s.sessions, id)
+			anonymous--
+			continue
+		}
+		if oldest == nil || rec.LastSeen.Before(oldest.LastSeen) {
+			oldest = rec
+		}
+	}
+	if anonymous > maxAnonymous && oldest != nil {
+		delete(s.sessions, oldest.ID)
+	}
+}
+
+// save writes every logged-in session to disk, dropping expired ones.
+// Callers hold s.mu.
+func (s *Store) save() error {
+	now := time.Now()
+	list := make([]*record, 0, len(s.sessions))
+	for id, rec := range s.sessions {
+		if s.expired(rec, now) {
+			delete(s.sessions, id)
+			continue
+		}
+		if rec.Username == "" {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(rec.values); err != nil {
+			return err
+		}
+		rec.Data = buf.Bytes()
+		rec.saved = rec.LastSeen
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data, 0600)
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,166 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+var testConfig = Config{IdleTimeout: 2 * time.Hour, AbsoluteTimeout: 24 * time.Hour}
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "sessions.json"), []byte("secret"), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// login saves a logged-in session for username and returns its cookie
+// and ID
+func login(t *testing.T, s *Store, username string) (*http.Cookie, string) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := s.New(r, "session")
+	session.Values[authenticatedKey] = true
+	session.Values[usernameKey] = username
+	w := httptest.NewRecorder()
+	if err := s.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()[0], session.ID
+}
+
+// load returns the session the cookie names, as the next request sees it
+func load(s *Store, cookie *http.Cookie) *sessions.Session {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, _ := s.New(r, "session")
+	return session
+}
+
+// age moves a session's timestamps into the past
+func age(s *Store, id string, created, lastSeen time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.sessions[id]
+	rec.Created = rec.Created.Add(-created)
+	rec.LastSeen = rec.LastSeen.Add(-lastSeen)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name              string
+		created, lastSeen time.Duration // how long ago
+		live              bool
+	}{
+		{"fresh", 0, 0, true},
+		{"idle but within the timeout", time.Hour, time.Hour, true},
+		{"idle too long", 3 * time.Hour, 3 * time.Hour, false},
+		{"busy but past the absolute timeout", 25 * time.Hour, time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t)
+			cookie, id := login(t, s, "alice")
+			age(s, id, tt.created, tt.lastSeen)
+
+			session := load(s, cookie)
+			if live := !session.IsNew && session.Values[usernameKey] == "alice"; live != tt.live {
+				t.Fatalf("session live = %v, want %v", live, tt.live)
+			}
+			if got := len(s.List("alice")); got != map[bool]int{true: 1, false: 0}[tt.live] {
+				t.Errorf("List = %d sessions", got)
+			}
+		})
+	}
+}
+
+func TestActivityPostponesIdleExpiry(t *testing.T) {
+	s := openStore(t)
+	cookie, id := login(t, s, "alice")
+
+	// Used every 90 minutes, the session outlives its 2 hour idle timeout
+	for range 3 {
+		age(s, id, 90*time.Minute, 90*time.Minute)
+		if load(s, cookie).IsNew {
+			t.Fatal("an active session expired")
+		}
+	}
+}
+
+func TestAnonymousSessionsExpireSooner(t *testing.T) {
+	s := openStore(t)
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := s.New(r, "session")
+	session.Values["csrfToken"] = "x"
+	w := httptest.NewRecorder()
+	s.Save(r, w, session)
+	cookie := w.Result().Cookies()[0]
+
+	age(s, session.ID, anonymousIdle+time.Minute, anonymousIdle+time.Minute)
+	if !load(s, cookie).IsNew {
+		t.Errorf("anonymous session outlived %v", anonymousIdle)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := openStore(t)
+	first, firstID := login(t, s, "alice")
+	second, secondID := login(t, s, "alice")
+	third, _ := login(t, s, "alice")
+	bob, _ := login(t, s, "bob")
+
+	if s.Revoke("bob", Ref(firstID)) {
+		t.Error("bob revoked alice's session")
+	}
+	if !s.Revoke("alice", Ref(firstID)) {
+		t.Fatal("Revoke found no session")
+	}
+	if !load(s, first).IsNew {
+		t.Error("revoked session still loads")
+	}
+
+	// Signing out everywhere else keeps the current session
+	if n := s.RevokeUser("alice", secondID); n != 1 {
+		t.Errorf("RevokeUser = %d, want 1", n)
+	}
+	if load(s, second).IsNew || !load(s, third).IsNew || load(s, bob).IsNew {
+		t.Error("RevokeUser ended the wrong sessions")
+	}
+
+	// Revocations survive a restart
+	reopened, err := Open(s.path, []byte("secret"), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !load(reopened, first).IsNew || !load(reopened, third).IsNew || load(reopened, second).IsNew {
+		t.Error("sessions file doesn't match the revocations")
+	}
+}
+
+func TestSaveAfterRevokeDropsLogin(t *testing.T) {
+	s := openStore(t)
+	cookie, _ := login(t, s, "alice")
+
+	// A request that loaded the session before it was revoked can't
+	// save the login back
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, _ := s.New(r, "session")
+	s.RevokeUser("alice", "")
+	if err := s.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := session.Values[usernameKey]; ok {
+		t.Error("the revoked login was saved again")
+	}
+	if len(s.List("alice")) != 0 {
+		t.Error("alice has a session again")
+	}
+}
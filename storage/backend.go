@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"time"
+)
+
+// Entry describes a single file or directory known to a Backend
+type Entry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend abstracts where content and images live. Paths are the same
+// filesystem-style paths the handlers build from the config directories.
+type Backend interface {
+	List(dir string) ([]Entry, error)
+	Get(path string) ([]byte, error)
+	Put(path string, data []byte) error
+	Delete(path string) error
+	Move(src, dst string) error
+	Stat(path string) (Entry, error)
+}
+
+var backend Backend = NewFSBackend()
+
+// SetBackend replaces the backend used by all storage functions
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// CurrentBackend returns the backend in use
+func CurrentBackend() Backend {
+	return backend
+}
+
+// List returns the entries of a directory
+func List(dir string) ([]Entry, error) {
+	return backend.List(dir)
+}
+
+// Get returns the raw bytes stored at path
+func Get(path string) ([]byte, error) {
+	return backend.Get(path)
+}
+
+// Put stores data at path, creating parent directories as needed
+func Put(path string, data []byte) error {
+	return backend.Put(path, data)
+}
+
+// Delete removes a file or a whole directory tree
+func Delete(path string) error {
+	return backend.Delete(path)
+}
+
+// Move renames src to dst, creating dst's parent directories as needed
+func Move(src, dst string) error {
+	return backend.Move(src, dst)
+}
+
+// Stat returns metadata for path
+func Stat(path string) (Entry, error) {
+	return backend.Stat(path)
+}
+
+// Committer is implemented by backends that record changes as commits
+type Committer interface {
+	Commit(message, author string, paths ...string) error
+}
+
+// Commit records a change to paths on backends that support it and is a
+// no-op for plain filesystem storage
+func Commit(message, author string, paths ...string) error {
+	if c, ok := backend.(Committer); ok {
+		return c.Commit(message, author, paths...)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cms/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// splitFrontmatter separates the YAML between the leading "---" fences from
+// the markdown body. The closing fence must be on a line of its own, so
+// values containing "---" don't end the frontmatter early.
+func splitFrontmatter(data []byte, name string) (string, string, error) {
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	if !strings.HasPrefix(content, "---\n") {
+		return "", "", fmt.Errorf("missing frontmatter in %s", name)
+	}
+
+	rest := content[len("---\n"):]
+	if strings.HasPrefix(rest, "---\n") || rest == "---" {
+		return "", strings.TrimSpace(strings.TrimPrefix(rest, "---")), nil
+	}
+
+	end := strings.Index(rest, "\n---\n")
+	if end < 0 {
+		if !strings.HasSuffix(rest, "\n---") {
+			return "", "", fmt.Errorf("invalid frontmatter format in %s", name)
+		}
+		end = len(rest) - len("\n---")
+	}
+
+	frontmatter := rest[:end]
+	body := strings.TrimPrefix(rest[end:], "\n---")
+	return frontmatter, strings.TrimSpace(body), nil
+}
+
+// mergeFrontmatter writes the modelled fields of item into an existing
+// frontmatter document. Keys the CMS doesn't know about, key order and
+// comments are kept; known keys that are missing are appended.
+func mergeFrontmatter(existing string, item model.Content) ([]byte, error) {
+	var doc yaml.Node
+	if strings.TrimSpace(existing) != "" {
+		if err := yaml.Unmarshal([]byte(existing), &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse existing frontmatter: %w", err)
+		}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	root := doc.Content[0]
+
+	setKey(root, "title", stringNode(item.Title))
+	setKey(root, "excerpt", stringNode(item.Excerpt))
+	setKey(root, "coverImage", stringNode(item.CoverImage))
+	setKey(root, "date", stringNode(item.Date))
+
+	ogImage := getKey(root, "ogImage")
+	if ogImage == nil || ogImage.Kind != yaml.MappingNode {
+		ogImage = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	setKey(ogImage, "url", stringNode(item.OGImage.URL))
+	setKey(root, "ogImage", ogImage)
+
+	if len(item.Gallery) > 0 {
+		var gallery yaml.Node
+		if err := gallery.Encode(item.Gallery); err != nil {
+			return nil, fmt.Errorf("failed to encode gallery: %w", err)
+		}
+		setKey(root, "gallery", &gallery)
+	} else {
+		removeKey(root, "gallery")
+	}
+
+	tags := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+	for _, t := range item.Tags {
+		tags.Content = append(tags.Content, stringNode(t))
+	}
+	setKey(root, "tags", tags)
+
+	setOptionalKey(root, "status", item.Status)
+	setOptionalKey(root, "publishAt", item.PublishAt)
+	setOptionalKey(root, "createdBy", item.CreatedBy)
+
+	// Extra fields sent by API clients are set on top of what's on disk
+	keys := make([]string, 0, len(item.Extra))
+	for k := range item.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var value yaml.Node
+		if err := value.Encode(item.Extra[k]); err != nil {
+			return nil, fmt.Errorf("failed to encode field %q: %w", k, err)
+		}
+		setKey(root, k, &value)
+	}
+
+	// The site only reads draft, so it follows status: everything but
+	// published is kept out of builds. Items without a status keep
+	// whatever draft they have.
+	switch item.Status {
+	case "":
+	case model.StatusPublished:
+		if getKey(root, "draft") != nil {
+			setKey(root, "draft", boolNode(false))
+		}
+	default:
+		setKey(root, "draft", boolNode(true))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func boolNode(value bool) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(value)}
+}
+
+func stringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle}
+}
+
+func getKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setOptionalKey sets a string key, or removes it when value is empty
+func setOptionalKey(mapping *yaml.Node, key, value string) {
+	if value != "" {
+		setKey(mapping, key, stringNode(value))
+		return
+	}
+	removeKey(mapping, key)
+}
+
+// removeKey deletes key and its value from a mapping node
+func removeKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// setKey replaces the value of key in a mapping node, or appends the key.
+// Comments and the quoting or flow style of the old value are carried over.
+func setKey(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+		old := mapping.Content[i+1]
+		if old != value {
+			value.HeadComment = old.HeadComment
+			value.LineComment = old.LineComment
+			value.FootComment = old.FootComment
+			if old.Kind == value.Kind && old.Kind != yaml.MappingNode {
+				value.Style = old.Style
+			}
+		}
+		mapping.Content[i+1] = value
+		return
+	}
+
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
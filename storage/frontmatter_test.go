@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cms/model"
+)
+
+func TestDraftFollowsStatus(t *testing.T) {
+	useHistoryDir(t)
+
+	tests := []struct {
+		status   string
+		existing string // frontmatter on disk before the save
+		want     string // the draft line written, "" for none
+	}{
+		{model.StatusDraft, "", "draft: true"},
+		{model.StatusScheduled, "draft: false\n", "draft: true"},
+		{model.StatusArchived, "", "draft: true"},
+		{model.StatusPublished, "draft: true # hidden\n", "draft: false # hidden"},
+		{model.StatusPublished, "", ""},
+		{"", "draft: true\n", "draft: true"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "hello.md")
+		if tt.existing != "" {
+			if err := os.WriteFile(path, []byte("---\ntitle: Hello\n"+tt.existing+"---\n\nBody\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		item := model.Content{Title: "Hello", Date: "2025-01-01", Tags: []string{"posts"}, Status: tt.status, Content: "Body"}
+		if existing, _, err := ReadContent(path); err == nil {
+			item.Extra = existing.Extra
+		}
+		if err := WriteContent(path, item); err != nil {
+			t.Fatalf("status %q: %v", tt.status, err)
+		}
+
+		data, _ := os.ReadFile(path)
+		var got string
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "draft:") {
+				got = line
+			}
+		}
+		if got != tt.want {
+			t.Errorf("status %q over %q wrote %q, want %q", tt.status, tt.existing, got, tt.want)
+		}
+	}
+}
+
+func TestReadersSplitOnFenceLinesOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dashes.md")
+	data := "---\ntitle: \"Before --- after\"\nexcerpt: a---b\n---\n\nAbove\n\n---\n\nBelow\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const wantBody = "Above\n\n---\n\nBelow"
+
+	post, body, err := ReadMarkdownWithFrontmatter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "Before --- after" || post.Excerpt != "a---b" || body != wantBody {
+		t.Errorf("ReadMarkdownWithFrontmatter = %q, %q, %q", post.Title, post.Excerpt, body)
+	}
+
+	item, body, err := ReadContent(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Title != post.Title || item.Excerpt != post.Excerpt || body != wantBody {
+		t.Errorf("ReadContent = %q, %q, %q", item.Title, item.Excerpt, body)
+	}
+}
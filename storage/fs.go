@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FSBackend stores everything directly on the local filesystem
+type FSBackend struct{}
+
+func NewFSBackend() *FSBackend {
+	return &FSBackend{}
+}
+
+func (b *FSBackend) List(dir string) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(files))
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entryFromInfo(info))
+	}
+	return entries, nil
+}
+
+func (b *FSBackend) Get(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (b *FSBackend) Put(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
+
+func (b *FSBackend) Delete(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.RemoveAll(path)
+	}
+	return os.Remove(path)
+}
+
+func (b *FSBackend) Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// src and dst are on different filesystems (e.g. separate Docker
+	// volumes), so copy atomically and remove the original
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(dst, data, 0644); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (b *FSBackend) Stat(path string) (Entry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	return entryFromInfo(info), nil
+}
+
+func entryFromInfo(info os.FileInfo) Entry {
+	return Entry{
+		Name:    info.Name(),
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
+
+// WriteFileAtomic writes data to a temp file in the same directory, syncs
+// it to disk and renames it over path, so a crash or full disk never
+// leaves a truncated file behind
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	// Clean up the temp file on any failure before the rename
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	ok = true
+
+	// Persist the rename itself; not every platform supports syncing a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
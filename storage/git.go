@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GitBackend stores files on disk like FSBackend and records every change
+// as a commit in the git repository that contains them
+type GitBackend struct {
+	FSBackend
+
+	repoDir string
+	remote  string
+	branch  string
+
+	mu sync.Mutex
+}
+
+// NewGitBackend opens the git repository containing dir. When remote is
+// set, every commit is pushed to it (to branch, or the current branch).
+func NewGitBackend(dir, remote, branch string) (*GitBackend, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &GitBackend{repoDir: abs, remote: remote, branch: branch}
+	top, err := b.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("%s is not inside a git repository: %w", dir, err)
+	}
+	b.repoDir = strings.TrimSpace(top)
+
+	return b, nil
+}
+
+// RepoDir returns the root of the working tree
+func (b *GitBackend) RepoDir() string {
+	return b.repoDir
+}
+
+// Commit stages the given paths (including deletions) and commits them.
+// Paths that git does not know about and that no longer exist are skipped,
+// and nothing is committed when the paths are unchanged.
+func (b *GitBackend) Commit(message, author string, paths ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var staged []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		if _, err := b.git("add", "-A", "--", abs); err != nil {
+			// Untracked paths that were already removed have nothing to stage
+			continue
+		}
+		staged = append(staged, abs)
+	}
+	if len(staged) == 0 {
+		return nil
+	}
+
+	diffArgs := append([]string{"diff", "--cached", "--quiet", "--"}, staged...)
+	if _, err := b.git(diffArgs...); err == nil {
+		return nil
+	}
+
+	args := []string{"commit", "-m", message}
+	if author != "" {
+		args = append(args, "--author", fmt.Sprintf("%s <%s@cms>", author, author))
+	}
+	args = append(args, "--")
+	args = append(args, staged...)
+	if _, err := b.git(args...); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+
+	if b.remote == "" {
+		return nil
+	}
+
+	ref := "HEAD"
+	if b.branch != "" {
+		ref = "HEAD:" + b.branch
+	}
+	if _, err := b.git("push", b.remote, ref); err != nil {
+		return fmt.Errorf("git push failed: %w", err)
+	}
+
+	return nil
+}
+
+func (b *GitBackend) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = b.repoDir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", err
+		}
+		return "", fmt.Errorf("%w: %s", err, msg)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs git in dir and returns its trimmed output
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newGitRepos creates a work repository with one commit and a bare
+// remote it pushes to, and makes a GitBackend the storage backend
+func newGitRepos(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	base := t.TempDir()
+	work := filepath.Join(base, "work")
+	remote := filepath.Join(base, "remote.git")
+	runGit(t, base, "init", "--bare", "-b", "main", remote)
+	runGit(t, base, "init", "-b", "main", work)
+	runGit(t, work, "config", "user.name", "CMS")
+	runGit(t, work, "config", "user.email", "cms@example.com")
+	runGit(t, work, "config", "commit.gpgsign", "false")
+	runGit(t, work, "remote", "add", "origin", remote)
+	if err := os.WriteFile(filepath.Join(work, "README"), []byte("content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "README")
+	runGit(t, work, "commit", "-m", "Initial commit")
+	runGit(t, work, "push", "origin", "main")
+	if err := os.Mkdir(filepath.Join(work, "_posts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewGitBackend(filepath.Join(work, "_posts"), "origin", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := backend
+	SetBackend(b)
+	t.Cleanup(func() { SetBackend(previous) })
+	return work, remote
+}
+
+func TestGitBackendCommitsAndPushes(t *testing.T) {
+	work, remote := newGitRepos(t)
+	path := filepath.Join(work, "_posts", "hello.md")
+
+	if err := Put(path, []byte("---\ntitle: Hello\n---\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit("Create posts/hello by alice", "alice", path); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, work, "log", "-1", "--format=%s|%an"); got != "Create posts/hello by alice|alice" {
+		t.Errorf("last commit = %q", got)
+	}
+
+	// Nothing changed, so nothing is committed
+	if err := Commit("Update posts/hello by alice", "alice", path); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, work, "rev-list", "--count", "HEAD"); got != "2" {
+		t.Errorf("commits after an empty change = %s, want 2", got)
+	}
+
+	if err := Delete(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit("Delete posts/hello by alice", "alice", path); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, work, "log", "-1", "--format=%s", "--name-status"); !strings.Contains(got, "D\t_posts/hello.md") {
+		t.Errorf("delete commit = %q, want the file removed", got)
+	}
+
+	if local, pushed := runGit(t, work, "rev-parse", "HEAD"), runGit(t, remote, "rev-parse", "main"); local != pushed {
+		t.Errorf("remote main = %s, want the local HEAD %s", pushed, local)
+	}
+}
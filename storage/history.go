@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyRoot holds one sub-folder of revisions per slug. It lives
+// outside the content folder, which is the site's own tree (and, with
+// git storage, its repository), so revisions never show up there.
+var historyRoot = "history"
+
+// SetHistoryDir sets the folder revisions are kept in
+func SetHistoryDir(dir string) {
+	historyRoot = dir
+}
+
+const revisionIDFormat = "20060102T150405.000000000Z"
+
+// Revision is a stored snapshot of a content file
+type Revision struct {
+	ID   string
+	Time time.Time
+	Size int64
+}
+
+// historyDir returns the revisions folder of the given markdown path
+func historyDir(path string) string {
+	slug := strings.TrimSuffix(filepath.Base(path), ".md")
+	return filepath.Join(historyRoot, slug)
+}
+
+// putWithHistory writes data to path and keeps a revision of it. The first
+// time a file is saved, its previous contents are kept as a revision too.
+func putWithHistory(path string, data []byte) error {
+	revisions, _ := ListRevisions(path)
+	if len(revisions) == 0 {
+		if info, err := backend.Stat(path); err == nil {
+			if old, err := backend.Get(path); err == nil {
+				saveRevision(path, old, info.ModTime)
+			}
+		}
+	}
+
+	if err := backend.Put(path, data); err != nil {
+		return err
+	}
+
+	return saveRevision(path, data, time.Now())
+}
+
+func saveRevision(path string, data []byte, at time.Time) error {
+	id := at.UTC().Format(revisionIDFormat)
+	return backend.Put(filepath.Join(historyDir(path), id+".md"), data)
+}
+
+// ListRevisions returns the stored revisions of a content file, newest first
+func ListRevisions(path string) ([]Revision, error) {
+	entries, err := backend.List(historyDir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	for _, e := range entries {
+		if e.IsDir || !strings.HasSuffix(e.Name, ".md") {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name, ".md")
+		t, err := time.Parse(revisionIDFormat, id)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, Revision{ID: id, Time: t, Size: e.Size})
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID > revisions[j].ID
+	})
+
+	return revisions, nil
+}
+
+// ReadRevision returns the raw contents of one revision
+func ReadRevision(path, id string) ([]byte, error) {
+	if _, err := time.Parse(revisionIDFormat, id); err != nil {
+		return nil, fmt.Errorf("invalid revision id %q", id)
+	}
+	return backend.Get(filepath.Join(historyDir(path), id+".md"))
+}
+
+// RestoreRevision writes revision id back as the current file at path,
+// byte for byte, and keeps it as a new revision
+func RestoreRevision(path, id string) error {
+	data, err := ReadRevision(path, id)
+	if err != nil {
+		return err
+	}
+	return putWithHistory(path, data)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cms/model"
+)
+
+// useHistoryDir keeps revisions in a fresh folder for the test
+func useHistoryDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	SetHistoryDir(dir)
+	t.Cleanup(func() { SetHistoryDir("history") })
+	return dir
+}
+
+func TestRestoreRevisionIsByteExact(t *testing.T) {
+	useHistoryDir(t)
+	path := filepath.Join(t.TempDir(), "hello.md")
+
+	if err := WriteContent(path, model.Content{Title: "Hello", Date: "2025-01-01", Tags: []string{"posts"}, Content: "First"}); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := ListRevisions(path)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("revisions after create = %v, %v", revisions, err)
+	}
+	first := revisions[0].ID
+	want, err := ReadRevision(path, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone adds a key by hand, then the item is saved again, which
+	// keeps it
+	data, _ := os.ReadFile(path)
+	data = bytes.Replace(data, []byte("---\n"), []byte("---\nseries: travel # hand-added\n"), 1)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteContent(path, model.Content{Title: "Hello again", Date: "2025-01-02", Tags: []string{"posts"}, Content: "Second"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Contains(data, []byte("series: travel")) {
+		t.Fatalf("the save dropped the hand-added key:\n%s", data)
+	}
+
+	if err := RestoreRevision(path, first); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("restored file =\n%s\nwant the revision exactly =\n%s", got, want)
+	}
+
+	revisions, _ = ListRevisions(path)
+	latest, _ := ReadRevision(path, revisions[0].ID)
+	if !bytes.Equal(latest, want) {
+		t.Errorf("the restore wasn't kept as the newest revision")
+	}
+}
+
+func TestRestoreRevisionRejectsBadIDs(t *testing.T) {
+	useHistoryDir(t)
+	path := filepath.Join(t.TempDir(), "hello.md")
+	for _, id := range []string{"", "../hello", "20250101T000000.000000000Z"} {
+		if err := RestoreRevision(path, id); err == nil {
+			t.Errorf("RestoreRevision(%q) succeeded", id)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("a failed restore created the file")
+	}
+}
+
+func TestHistoryStaysOutOfContentDir(t *testing.T) {
+	history := useHistoryDir(t)
+	content := t.TempDir()
+	path := filepath.Join(content, "hello.md")
+
+	for _, title := range []string{"Hello", "Hello again"} {
+		if err := WriteContent(path, model.Content{Title: title, Tags: []string{"posts"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "hello.md" {
+		t.Errorf("content folder holds %v, want only hello.md", entries)
+	}
+	revisions, err := os.ReadDir(filepath.Join(history, "hello"))
+	if err != nil || len(revisions) != 2 {
+		t.Errorf("history holds %v, %v; want 2 revisions", revisions, err)
+	}
+}
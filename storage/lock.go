@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+var (
+	pathLocksMu sync.Mutex
+	pathLocks   = make(map[string]*pathLock)
+)
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock serializes writers to a single path and returns the unlock func.
+// Handlers hold it across read-check-write sequences (If-Match checks,
+// frontmatter merges) so concurrent requests for the same slug can't
+// interleave.
+func Lock(path string) func() {
+	key := path
+	if abs, err := filepath.Abs(path); err == nil {
+		key = abs
+	}
+
+	pathLocksMu.Lock()
+	l, ok := pathLocks[key]
+	if !ok {
+		l = &pathLock{}
+		pathLocks[key] = l
+	}
+	l.refs++
+	pathLocksMu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		pathLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(pathLocks, key)
+		}
+		pathLocksMu.Unlock()
+	}
+}
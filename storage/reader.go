@@ -2,33 +2,25 @@ package storage
 
 import (
 	"cms/model"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"os"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 func ReadMarkdownWithFrontmatter(path string) (model.BlogPost, string, error) {
-	data, err := os.ReadFile(path)
+	data, err := backend.Get(path)
 	if err != nil {
 		return model.BlogPost{}, "", fmt.Errorf("failed to read file: %w", err)
 	}
 
-	content := string(data)
-
-	if !strings.HasPrefix(content, "---") {
-		return model.BlogPost{}, "", fmt.Errorf("missing frontmatter in %s", path)
-	}
-
-	parts := strings.SplitN(content, "---", 3)
-	if len(parts) < 3 {
-		return model.BlogPost{}, "", fmt.Errorf("invalid frontmatter format in %s", path)
+	frontmatterYaml, body, err := splitFrontmatter(data, path)
+	if err != nil {
+		return model.BlogPost{}, "", err
 	}
 
-	frontmatterYaml := strings.TrimSpace(parts[1])
-	body := strings.TrimSpace(parts[2])
-
 	var post model.BlogPost
 	if err := yaml.Unmarshal([]byte(frontmatterYaml), &post); err != nil {
 		return model.BlogPost{}, "", fmt.Errorf("failed to parse frontmatter: %w", err)
@@ -39,29 +31,46 @@ func ReadMarkdownWithFrontmatter(path string) (model.BlogPost, string, error) {
 
 // ReadContent reads any content type from markdown with frontmatter
 func ReadContent(path string) (model.Content, string, error) {
-	data, err := os.ReadFile(path)
+	data, err := backend.Get(path)
 	if err != nil {
 		return model.Content{}, "", fmt.Errorf("failed to read file: %w", err)
 	}
 
-	content := string(data)
-
-	if !strings.HasPrefix(content, "---") {
-		return model.Content{}, "", fmt.Errorf("missing frontmatter in %s", path)
-	}
+	return ParseContent(data, path)
+}
 
-	parts := strings.SplitN(content, "---", 3)
-	if len(parts) < 3 {
-		return model.Content{}, "", fmt.Errorf("invalid frontmatter format in %s", path)
+// ParseContent parses markdown with frontmatter; name is only used in errors
+func ParseContent(data []byte, name string) (model.Content, string, error) {
+	frontmatterYaml, body, err := splitFrontmatter(data, name)
+	if err != nil {
+		return model.Content{}, "", err
 	}
 
-	frontmatterYaml := strings.TrimSpace(parts[1])
-	body := strings.TrimSpace(parts[2])
-
 	var item model.Content
 	if err := yaml.Unmarshal([]byte(frontmatterYaml), &item); err != nil {
 		return model.Content{}, "", fmt.Errorf("failed to parse frontmatter: %w", err)
 	}
+	item.ETag = ETag(data)
 
 	return item, body, nil
 }
+
+// ETag returns a strong entity tag for the raw bytes of a file
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ETagMatches reports whether an If-Match / If-None-Match header value
+// matches etag. Weak validators and unquoted values are compared by their
+// opaque value.
+func ETagMatches(header, etag string) bool {
+	etag = strings.Trim(etag, `"`)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
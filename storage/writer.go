@@ -2,7 +2,7 @@ package storage
 
 import (
 	"fmt"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -10,47 +10,70 @@ import (
 )
 
 func WriteMarkdownWithFrontmatter(path string, post model.BlogPost) error {
+	return WriteContent(path, post.ToContent(""))
+}
+
+// WriteContent writes any content type to markdown with frontmatter
+func WriteContent(path string, content model.Content) error {
 	// fallback to current date if not supplied
-	date := post.Date
-	if date == "" {
-		date = time.Now().Format("2006-01-02")
+	if content.Date == "" {
+		content.Date = time.Now().Format("2006-01-02")
+	}
+
+	// Merge into the frontmatter already on disk so fields the CMS doesn't
+	// model (author, draft, series, ...) survive the save
+	existing := ""
+	if data, err := backend.Get(path); err == nil {
+		existing, _, _ = splitFrontmatter(data, path)
 	}
 
-	frontmatter := fmt.Sprintf(`---
-title: "%s"
-excerpt: "%s"
-coverImage: "%s"
-date: "%s"
-ogImage:
-  url: "%s"
-tags: [%s]
----
+	frontmatter, err := mergeFrontmatter(existing, content)
+	if err != nil {
+		return err
+	}
 
-`, post.Title, post.Excerpt, post.CoverImage, date, post.OGImage.URL, strings.Join(post.Tags, ", "))
+	fullContent := []byte("---\n" + string(frontmatter) + "---\n\n" + content.Content)
+	if err := verifyRoundTrip(fullContent, content); err != nil {
+		return fmt.Errorf("refusing to write %s: %w", path, err)
+	}
 
-	fullContent := frontmatter + post.Content
-	return os.WriteFile(path, []byte(fullContent), 0644)
+	return putWithHistory(path, fullContent)
 }
 
-// WriteContent writes any content type to markdown with frontmatter
-func WriteContent(path string, content model.Content) error {
-	date := content.Date
-	if date == "" {
-		date = time.Now().Format("2006-01-02")
+// verifyRoundTrip parses a rendered file back and checks that every
+// modelled field reads back exactly as it was given
+func verifyRoundTrip(data []byte, want model.Content) error {
+	got, body, err := ParseContent(data, "rendered content")
+	if err != nil {
+		return err
 	}
 
-	frontmatter := fmt.Sprintf(`---
-title: "%s"
-excerpt: "%s"
-coverImage: "%s"
-date: "%s"
-ogImage:
-  url: "%s"
-tags: [%s]
----
-
-`, content.Title, content.Excerpt, content.CoverImage, date, content.OGImage.URL, strings.Join(content.Tags, ", "))
+	switch {
+	case got.Title != want.Title:
+		return fmt.Errorf("title does not round-trip")
+	case got.Excerpt != want.Excerpt:
+		return fmt.Errorf("excerpt does not round-trip")
+	case got.CoverImage != want.CoverImage:
+		return fmt.Errorf("coverImage does not round-trip")
+	case got.Date != want.Date:
+		return fmt.Errorf("date does not round-trip")
+	case got.OGImage.URL != want.OGImage.URL:
+		return fmt.Errorf("ogImage.url does not round-trip")
+	case !slices.Equal(got.Gallery, want.Gallery):
+		return fmt.Errorf("gallery does not round-trip")
+	case !slices.Equal(got.Tags, want.Tags):
+		return fmt.Errorf("tags do not round-trip")
+	case got.Status != want.Status:
+		return fmt.Errorf("status does not round-trip")
+	case want.Status != "" && got.Draft() != (want.Status != model.StatusPublished):
+		return fmt.Errorf("draft does not follow status")
+	case got.PublishAt != want.PublishAt:
+		return fmt.Errorf("publishAt does not round-trip")
+	case got.CreatedBy != want.CreatedBy:
+		return fmt.Errorf("createdBy does not round-trip")
+	case body != strings.TrimSpace(want.Content):
+		return fmt.Errorf("body does not round-trip")
+	}
 
-	fullContent := frontmatter + content.Content
-	return os.WriteFile(path, []byte(fullContent), 0644)
+	return nil
 }
@@ -0,0 +1,156 @@
+package users
+
+import (
+	"sync"
+	"time"
+)
+
+// LimiterConfig sets how many failed logins are tolerated
+type LimiterConfig struct {
+	MaxFailures int           // failures within Window before a lockout
+	Window      time.Duration // failures older than this are forgotten
+	Lockout     time.Duration // how long a locked key stays locked
+	BaseDelay   time.Duration // wait after the first failure, doubled after each one
+	MaxDelay    time.Duration // cap on the backoff wait
+}
+
+// Limiter slows down and then locks out repeated failed logins. Keys are
+// opaque, so one limiter can track both usernames and client IPs.
+type Limiter struct {
+	cfg LimiterConfig
+
+	mu      sync.Mutex
+	entries map[string]*limitEntry
+}
+
+type limitEntry struct {
+	failures    int
+	pending     int // reserved attempts that haven't failed or succeeded yet
+	first       time.Time
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// NewLimiter returns a limiter for cfg
+func NewLimiter(cfg LimiterConfig) *Limiter {
+	return &Limiter{cfg: cfg, entries: make(map[string]*limitEntry)}
+}
+
+// Reserve returns how long the caller must wait before key may try
+// again. When it returns zero the attempt is counted as in flight, and
+// is treated like a failure until Fail, Release or Reset settles it:
+// otherwise a burst of parallel logins would all pass the check before
+// the slow password hash of the first one recorded a failure.
+func (l *Limiter) Reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := l.current(key, now)
+	if e == nil {
+		if len(l.entries) > 10000 {
+			l.prune(now)
+		}
+		e = &limitEntry{first: now}
+		l.entries[key] = e
+	}
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+	attempts := e.failures + e.pending
+	if e.pending > 0 && attempts >= l.cfg.MaxFailures {
+		// Enough attempts are in flight to lock key if they fail
+		return max(l.backoff(attempts), time.Second)
+	}
+	if next := e.last.Add(l.backoff(attempts)); now.Before(next) {
+		return next.Sub(now)
+	}
+	e.pending++
+	e.last = now
+	return 0
+}
+
+// Fail settles a reserved attempt as failed and reports whether key is
+// now locked out
+func (l *Limiter) Fail(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &limitEntry{first: now}
+		l.entries[key] = e
+	}
+	if e.pending > 0 {
+		e.pending--
+	}
+	e.failures++
+	e.last = now
+	if e.failures >= l.cfg.MaxFailures {
+		e.lockedUntil = now.Add(l.cfg.Lockout)
+		return true
+	}
+	return false
+}
+
+// Release settles a reserved attempt that didn't fail without clearing
+// key's earlier failures, e.g. for the client IP of a successful login
+func (l *Limiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok && e.pending > 0 {
+		e.pending--
+		if e.pending == 0 && e.failures == 0 {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Reset forgets key's failures, and any attempts in flight, after a
+// successful login
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// backoff is the delay after n failures: nothing after the first, then
+// BaseDelay doubling up to MaxDelay
+func (l *Limiter) backoff(n int) time.Duration {
+	if n < 2 {
+		return 0
+	}
+	d := l.cfg.BaseDelay
+	for i := 2; i < n && d < l.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, l.cfg.MaxDelay)
+}
+
+// current returns key's entry, dropping it once it has expired. Callers
+// hold l.mu.
+func (l *Limiter) current(key string, now time.Time) *limitEntry {
+	e, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+	if l.expired(e, now) {
+		delete(l.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (l *Limiter) expired(e *limitEntry, now time.Time) bool {
+	return e.pending == 0 && now.After(e.lockedUntil) && now.Sub(e.last) > l.cfg.Window
+}
+
+// prune drops every expired entry so a flood of keys can't grow the map
+// forever. Callers hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
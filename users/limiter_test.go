@@ -0,0 +1,95 @@
+package users
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testLimits = LimiterConfig{
+	MaxFailures: 5,
+	Window:      15 * time.Minute,
+	Lockout:     15 * time.Minute,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+func TestLimiterBackoffAndLockout(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	// Each failure waits a little longer, until the fifth locks the key
+	waits := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, want := range waits {
+		now = now.Add(want)
+		if want > 0 && l.Reserve("alice", now.Add(-time.Nanosecond)) == 0 {
+			t.Fatalf("attempt %d went ahead before its %v backoff", i+1, want)
+		}
+		if wait := l.Reserve("alice", now); wait != 0 {
+			t.Fatalf("attempt %d: wait %v, want none", i+1, wait)
+		}
+		if locked := l.Fail("alice", now); locked != (i == len(waits)-1) {
+			t.Fatalf("attempt %d: locked = %v", i+1, locked)
+		}
+	}
+	if wait := l.Reserve("alice", now.Add(time.Minute)); wait != 14*time.Minute {
+		t.Errorf("wait while locked = %v, want 14m", wait)
+	}
+
+	l.Reset("alice")
+	if wait := l.Reserve("alice", now); wait != 0 {
+		t.Errorf("wait after reset = %v", wait)
+	}
+}
+
+func TestLimiterReleaseKeepsFailures(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Now()
+	for range 2 {
+		l.Reserve("203.0.113.7", now)
+		l.Fail("203.0.113.7", now)
+	}
+	now = now.Add(time.Second)
+	l.Reserve("203.0.113.7", now)
+	l.Release("203.0.113.7")
+
+	// Still two failures: the next attempt backs off again
+	now = now.Add(time.Second)
+	if wait := l.Reserve("203.0.113.7", now); wait != 0 {
+		t.Fatalf("wait after release = %v", wait)
+	}
+	if locked := l.Fail("203.0.113.7", now); locked {
+		t.Errorf("locked after 3 failures")
+	}
+	if wait := l.Reserve("203.0.113.7", now.Add(time.Second)); wait == 0 {
+		t.Errorf("the released attempt cleared the earlier failures")
+	}
+}
+
+func TestLimiterCapsParallelBurst(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Now()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if l.Reserve("alice", now) == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// The same as one after another: the third attempt has to wait for
+	// the first two, whether or not they have failed yet
+	if got := allowed.Load(); got != 2 {
+		t.Errorf("%d of 50 parallel attempts went ahead, want 2", got)
+	}
+}
@@ -0,0 +1,39 @@
+package users
+
+// Permissions checked by the handlers
+const (
+	PermRead        = "read"         // view dashboard, lists, previews, history, search
+	PermCreate      = "create"       // create content and upload images
+	PermEditOwn     = "edit_own"     // edit and restore items you created
+	PermEditAny     = "edit_any"     // edit and restore anyone's items
+	PermDelete      = "delete"       // delete content
+	PermReindex     = "reindex"      // rebuild the content index
+	PermManageUsers = "manage_users" // create, change and remove accounts
+	PermViewAudit   = "view_audit"   // read the audit log
+	PermManageMedia = "manage_media" // find unused and missing images, trash unused ones
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:  {PermRead, PermCreate, PermEditOwn, PermEditAny, PermDelete, PermReindex, PermManageUsers, PermViewAudit, PermManageMedia},
+	RoleEditor: {PermRead, PermCreate, PermEditOwn, PermEditAny, PermReindex},
+	RoleAuthor: {PermRead, PermCreate, PermEditOwn},
+	RoleViewer: {PermRead},
+}
+
+// Can reports whether the user's role grants perm
+func (u User) Can(perm string) bool {
+	for _, p := range rolePermissions[u.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// CanEdit reports whether the user may change an item created by owner
+func (u User) CanEdit(owner string) bool {
+	if u.Can(PermEditAny) {
+		return true
+	}
+	return u.Can(PermEditOwn) && owner != "" && owner == u.Username
+}
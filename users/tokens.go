@@ -0,0 +1,135 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tokenPrefix marks CMS API tokens so they are easy to spot in scripts
+// and secret scanners. A token is "cms_<id>_<secret>".
+const tokenPrefix = "cms_"
+
+// lastUsedGranularity limits how often a token's LastUsed time is written
+// back to disk
+const lastUsedGranularity = time.Minute
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token is a personal API token. Only a hash of the secret is stored.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	ReadOnly  bool      `json:"readOnly,omitempty"`
+	Types     []string  `json:"types,omitempty"` // content type slugs; empty means all
+	CreatedAt time.Time `json:"createdAt"`
+	LastUsed  time.Time `json:"lastUsed,omitzero"`
+}
+
+// Allows reports whether the token's scope covers perm on the given
+// content type. Type-scoped tokens can only reach routes with a type.
+func (t Token) Allows(perm, typeSlug string) bool {
+	if t.ReadOnly && perm != PermRead {
+		return false
+	}
+	if len(t.Types) > 0 && !slices.Contains(t.Types, typeSlug) {
+		return false
+	}
+	return true
+}
+
+// CreateToken issues a new token for the user and returns its plaintext,
+// which can't be recovered later
+func (s *Store) CreateToken(username, name string, readOnly bool, types []string) (string, Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", Token{}, fmt.Errorf("token name is required")
+	}
+
+	idBytes := make([]byte, 4)
+	secretBytes := make([]byte, 24)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", Token{}, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", Token{}, err
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	token := Token{
+		ID:        hex.EncodeToString(idBytes),
+		Name:      name,
+		Hash:      hashTokenSecret(secret),
+		ReadOnly:  readOnly,
+		Types:     types,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err := s.update(username, func(u *User) error {
+		u.Tokens = append(slices.Clone(u.Tokens), token)
+		return nil
+	})
+	if err != nil {
+		return "", Token{}, err
+	}
+	return tokenPrefix + token.ID + "_" + secret, token, nil
+}
+
+// RevokeToken deletes one of the user's tokens
+func (s *Store) RevokeToken(username, id string) error {
+	return s.update(username, func(u *User) error {
+		i := slices.IndexFunc(u.Tokens, func(t Token) bool { return t.ID == id })
+		if i < 0 {
+			return ErrTokenNotFound
+		}
+		u.Tokens = slices.Delete(slices.Clone(u.Tokens), i, i+1)
+		return nil
+	})
+}
+
+// AuthenticateToken finds the user owning a plaintext token and records
+// when it was used
+func (s *Store) AuthenticateToken(plain string, now time.Time) (User, Token, bool) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(plain, tokenPrefix), "_")
+	if !ok || !strings.HasPrefix(plain, tokenPrefix) {
+		return User{}, Token{}, false
+	}
+	hash := hashTokenSecret(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		for i, t := range u.Tokens {
+			if t.ID != id || subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+				continue
+			}
+			if now.Sub(t.LastUsed) >= lastUsedGranularity {
+				updated := *u
+				updated.Tokens = slices.Clone(u.Tokens)
+				updated.Tokens[i].LastUsed = now.UTC()
+				s.users[u.Username] = &updated
+				if err := s.save(); err != nil {
+					// Authentication still succeeds; only the timestamp is lost
+					s.users[u.Username] = u
+				} else {
+					u, t = &updated, updated.Tokens[i]
+				}
+			}
+			return *u, t, true
+		}
+	}
+	return User{}, Token{}, false
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,217 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters every authenticator app understands
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // steps accepted either side of now, for clock drift
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidCode    = errors.New("invalid code")
+	ErrTOTPNotPending = errors.New("two-factor setup has not been started")
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrTOTPAlreadySet = errors.New("two-factor authentication is already enabled")
+)
+
+var totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// HasTwoFactor reports whether the user must pass a second login step
+func (u User) HasTwoFactor() bool {
+	return u.TOTPEnabled && u.TOTPSecret != ""
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps scan
+func ProvisioningURI(issuer, username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// BeginTOTP generates a new secret for the user. It isn't required at
+// login until EnableTOTP confirms the user can produce codes from it.
+func (s *Store) BeginTOTP(username string) (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := totpSecretEncoding.EncodeToString(raw)
+
+	err := s.update(username, func(u *User) error {
+		if u.HasTwoFactor() {
+			return ErrTOTPAlreadySet
+		}
+		u.TOTPSecret = secret
+		return nil
+	})
+	return secret, err
+}
+
+// EnableTOTP turns on two-factor login once code matches the pending
+// secret, and returns a fresh set of recovery codes
+func (s *Store) EnableTOTP(username, code string, now time.Time) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.update(username, func(u *User) error {
+		if u.HasTwoFactor() {
+			return ErrTOTPAlreadySet
+		}
+		if u.TOTPSecret == "" {
+			return ErrTOTPNotPending
+		}
+		step, ok := validateTOTP(u.TOTPSecret, code, now)
+		if !ok {
+			return ErrInvalidCode
+		}
+		u.TOTPEnabled = true
+		u.TOTPLastStep = step
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the user's secret and recovery codes
+func (s *Store) DisableTOTP(username string) error {
+	return s.update(username, func(u *User) error {
+		u.TOTPSecret = ""
+		u.TOTPEnabled = false
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (s *Store) RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(username, func(u *User) error {
+		if !u.HasTwoFactor() {
+			return ErrTOTPNotEnabled
+		}
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a login code, which is either a current TOTP
+// code or an unused recovery code. Each TOTP step and each recovery code
+// is accepted only once.
+func (s *Store) VerifySecondFactor(username, code string, now time.Time) error {
+	code = strings.TrimSpace(code)
+	return s.update(username, func(u *User) error {
+		if !u.HasTwoFactor() {
+			return ErrTOTPNotEnabled
+		}
+
+		if step, ok := validateTOTP(u.TOTPSecret, code, now); ok {
+			if step <= u.TOTPLastStep {
+				return ErrInvalidCode
+			}
+			u.TOTPLastStep = step
+			return nil
+		}
+
+		hash := hashRecoveryCode(code)
+		for i, h := range u.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return ErrInvalidCode
+	})
+}
+
+// validateTOTP checks code against the steps around now and returns the
+// step it matched
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpSecretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode is the RFC 4226 HOTP value for a counter
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// newRecoveryCodes returns codes to show the user once, and the hashes
+// to store. Codes are random enough that a plain SHA-256 is sufficient.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpSecretEncoding.EncodeToString(raw))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package users
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890"
+var rfcSecret = totpSecretEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; a 6-digit code is their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		if got := totpCode([]byte("12345678901234567890"), tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+		step, ok := validateTOTP(rfcSecret, tt.code, now)
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("validateTOTP(%s) at %d = %d, %v", tt.code, tt.unix, step, ok)
+		}
+		// Secrets are often typed in lower case
+		if _, ok := validateTOTP(strings.ToLower(rfcSecret), tt.code, now); !ok {
+			t.Errorf("lower-case secret rejected at %d", tt.unix)
+		}
+	}
+}
+
+func TestTOTPSkew(t *testing.T) {
+	// 1111111111 is step 37037037; its code is accepted one step either side
+	code := "050471"
+	step := int64(1111111111 / totpPeriod)
+	tests := []struct {
+		offset int64 // steps from the code's own
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		now := time.Unix((step+tt.offset)*totpPeriod, 0)
+		got, ok := validateTOTP(rfcSecret, code, now)
+		if ok != tt.ok || (ok && got != step) {
+			t.Errorf("%+d steps: validateTOTP = %d, %v, want ok %v", tt.offset, got, ok, tt.ok)
+		}
+	}
+
+	for _, bad := range []string{"", "05047", "0504711", "abcdef"} {
+		if _, ok := validateTOTP(rfcSecret, bad, time.Unix(1111111111, 0)); ok {
+			t.Errorf("validateTOTP accepted %q", bad)
+		}
+	}
+}
+
+// enrolledStore returns a store with alice enrolled in two-factor login
+// at now, and her recovery codes
+func enrolledStore(t *testing.T, now time.Time) (*Store, []string) {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create("alice", "password1", RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := s.BeginTOTP("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := s.EnableTOTP("alice", codeAt(t, secret, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, codes
+}
+
+func codeAt(t *testing.T, secret string, now time.Time) string {
+	t.Helper()
+	key, err := totpSecretEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, now.Unix()/totpPeriod)
+}
+
+func TestTOTPStepIsSingleUse(t *testing.T) {
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	s, _ := enrolledStore(t, now)
+	alice, _ := s.Get("alice")
+
+	// The step used to enable two-factor login can't log in
+	if err := s.VerifySecondFactor("alice", codeAt(t, alice.TOTPSecret, now), now); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("enrollment code reused: %v", err)
+	}
+
+	next := now.Add(totpPeriod * time.Second)
+	code := codeAt(t, alice.TOTPSecret, next)
+	if err := s.VerifySecondFactor("alice", code, next); err != nil {
+		t.Fatalf("fresh code: %v", err)
+	}
+	if err := s.VerifySecondFactor("alice", code, next.Add(10*time.Second)); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replayed code: %v", err)
+	}
+	// Nor can an earlier step that is still within the skew
+	if err := s.VerifySecondFactor("alice", codeAt(t, alice.TOTPSecret, now), next); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("older step accepted: %v", err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	s, codes := enrolledStore(t, now)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Typed without the dash, in capitals and with spaces around it
+	typed := "  " + strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")) + " "
+	if err := s.VerifySecondFactor("alice", typed, now); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := s.VerifySecondFactor("alice", codes[0], now); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("used recovery code accepted again: %v", err)
+	}
+	if err := s.VerifySecondFactor("alice", codes[1], now); err != nil {
+		t.Errorf("second recovery code: %v", err)
+	}
+	if alice, _ := s.Get("alice"); len(alice.RecoveryCodes) != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", len(alice.RecoveryCodes), recoveryCodeCount-2)
+	}
+
+	// New codes replace the old ones
+	fresh, err := s.RegenerateRecoveryCodes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifySecondFactor("alice", codes[2], now); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replaced recovery code accepted: %v", err)
+	}
+	if err := s.VerifySecondFactor("alice", fresh[0], now); err != nil {
+		t.Errorf("regenerated recovery code: %v", err)
+	}
+}
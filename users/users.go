@@ -0,0 +1,292 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"cms/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles, from most to least privileged
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
+	RoleViewer = "viewer"
+)
+
+// Roles lists every valid role
+var Roles = []string{RoleAdmin, RoleEditor, RoleAuthor, RoleViewer}
+
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrExists   = errors.New("user already exists")
+
+	validUsername = regexp.MustCompile(`^[a-zA-Z0-9._@-]{1,64}$`)
+)
+
+// User is an account that can log into the CMS
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"`
+	Role         string `json:"role"`
+
+	// Two-factor login. TOTPSecret is set while enrollment is pending;
+	// TOTPEnabled once the user has confirmed a code from it.
+	TOTPSecret    string   `json:"totpSecret,omitempty"`
+	TOTPEnabled   bool     `json:"totpEnabled,omitempty"`
+	TOTPLastStep  int64    `json:"totpLastStep,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"` // SHA-256 hashes
+
+	Tokens []Token `json:"tokens,omitempty"`
+
+	// ExternalID links the account to a single sign-on identity. Such
+	// accounts have no password and get their role from the issuer.
+	ExternalID string `json:"externalID,omitempty"`
+}
+
+// Store keeps users in a JSON file
+type Store struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// dummyHash is compared against when a username doesn't exist, so a login
+// attempt takes the same time whether or not the account is real
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// Open loads the users file at path; a missing file is an empty store
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, users: make(map[string]*User)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, u := range list {
+		s.users[u.Username] = u
+	}
+	return s, nil
+}
+
+// save writes all users back to disk. Callers hold s.mu.
+func (s *Store) save() error {
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data, 0600)
+}
+
+// Len returns the number of users
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// List returns every user sorted by username
+func (s *Store) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	return list
+}
+
+// Get returns the user with the given username
+func (s *Store) Get(username string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[username]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// Authenticate checks a username and password. It takes the same time
+// whether or not the account exists or has a password, so response times
+// don't reveal which usernames are real.
+func (s *Store) Authenticate(username, password string) (User, bool) {
+	u, ok := s.Get(username)
+	if !ok || u.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+	return u, true
+}
+
+// Create adds a new user
+func (s *Store) Create(username, password, role string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return ErrExists
+	}
+	s.users[username] = &User{Username: username, PasswordHash: hash, Role: role}
+	return s.save()
+}
+
+// LinkExternal returns the account for a single sign-on identity,
+// creating it on first login and syncing its role on every login. An
+// existing local account with the same username is never taken over.
+func (s *Store) LinkExternal(externalID, username, role string) (User, error) {
+	if !ValidRole(role) {
+		return User{}, fmt.Errorf("invalid role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.ExternalID != externalID {
+			continue
+		}
+		if u.Role != role {
+			updated := *u
+			updated.Role = role
+			s.users[u.Username] = &updated
+			if err := s.save(); err != nil {
+				s.users[u.Username] = u
+				return User{}, err
+			}
+			return updated, nil
+		}
+		return *u, nil
+	}
+
+	if !validUsername.MatchString(username) {
+		return User{}, fmt.Errorf("invalid username %q", username)
+	}
+	if _, ok := s.users[username]; ok {
+		return User{}, fmt.Errorf("account %s already exists and is not linked to this identity", username)
+	}
+	u := &User{Username: username, Role: role, ExternalID: externalID}
+	s.users[username] = u
+	if err := s.save(); err != nil {
+		delete(s.users, username)
+		return User{}, err
+	}
+	return *u, nil
+}
+
+// SetRole changes a user's role
+func (s *Store) SetRole(username, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	return s.update(username, func(u *User) error {
+		u.Role = role
+		return nil
+	})
+}
+
+// SetPassword replaces a user's password
+func (s *Store) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.update(username, func(u *User) error {
+		u.PasswordHash = hash
+		return nil
+	})
+}
+
+// Delete removes a user
+func (s *Store) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, username)
+	return s.save()
+}
+
+// update applies fn to a user and saves the store
+func (s *Store) update(username string, fn func(u *User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	updated := *u
+	if err := fn(&updated); err != nil {
+		return err
+	}
+	s.users[username] = &updated
+	return s.save()
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", fmt.Errorf("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
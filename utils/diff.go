@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+)
+
+// DiffLine is one line of a line diff. Op is "+" for added lines, "-" for
+// removed lines and " " for lines both sides share.
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// DiffLines computes a line-based diff from a to b using the longest common
+// subsequence of lines
+func DiffLines(a, b string) []DiffLine {
+	left := strings.Split(a, "\n")
+	right := strings.Split(b, "\n")
+
+	// lcs[i][j] is the LCS length of left[i:] and right[j:]
+	lcs := make([][]int, len(left)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(right)+1)
+	}
+	for i := len(left) - 1; i >= 0; i-- {
+		for j := len(right) - 1; j >= 0; j-- {
+			if left[i] == right[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []DiffLine
+	i, j := 0, 0
+	for i < len(left) && j < len(right) {
+		switch {
+		case left[i] == right[j]:
+			lines = append(lines, DiffLine{Op: " ", Text: left[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{Op: "-", Text: left[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: "+", Text: right[j]})
+			j++
+		}
+	}
+	for ; i < len(left); i++ {
+		lines = append(lines, DiffLine{Op: "-", Text: left[i]})
+	}
+	for ; j < len(right); j++ {
+		lines = append(lines, DiffLine{Op: "+", Text: right[j]})
+	}
+
+	return lines
+}
@@ -1,13 +1,130 @@
 package utils
 
 import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
 
+var (
+	ErrInvalidSlug = errors.New("slug may only contain letters, digits, '-', '_' and '.', must start with a letter or digit, and be at most 128 characters")
+	ErrInvalidName = errors.New("invalid file name")
+	ErrOutsideRoot = errors.New("path is outside its root directory")
+
+	// A single path element: no separators, no leading dot (so no "..",
+	// hidden files), nothing that needs quoting
+	validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+)
+
 func Slugify(title string) string {
 	slug := strings.ToLower(title)
 	slug = strings.ReplaceAll(slug, " ", "_")
 	slug = regexp.MustCompile(`[^\w_]+`).ReplaceAllString(slug, "")
 	return slug
 }
+
+// CleanSlug canonicalizes a slug from a URL or request body: surrounding
+// spaces and a trailing ".md" are dropped. Anything that could name a file
+// outside a single directory is rejected with ErrInvalidSlug.
+func CleanSlug(slug string) (string, error) {
+	slug = strings.TrimSuffix(strings.TrimSpace(slug), ".md")
+	if !validName.MatchString(slug) {
+		return "", ErrInvalidSlug
+	}
+	return slug, nil
+}
+
+// SlugPath returns root/slug+ext for a cleaned slug, e.g. the markdown
+// file of an item (ext ".md") or its image folder (ext "")
+func SlugPath(root, slug, ext string) (string, error) {
+	clean, err := CleanSlug(slug)
+	if err != nil {
+		return "", err
+	}
+	return SafeJoin(root, clean+ext)
+}
+
+// LegacySlugPath returns root/slug+ext for a file that already exists
+// under a name from before slugs were checked, e.g. with spaces or a
+// leading underscore, so it can still be read and deleted. The name must
+// still be a single visible path element inside root.
+func LegacySlugPath(root, slug, ext string) (string, error) {
+	name := slug + ext
+	if slug == "" || slug == "." || slug == ".." || strings.HasPrefix(name, ".") ||
+		strings.ContainsAny(name, "/\\\x00") {
+		return "", ErrInvalidSlug
+	}
+	path := filepath.Join(root, name)
+	if err := Within(root, path); err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", ErrInvalidSlug
+	}
+	return path, nil
+}
+
+// SafeJoin joins root and a single file name, such as an uploaded file's
+// generated name, refusing names that aren't a plain path element
+func SafeJoin(root, name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", ErrInvalidName
+	}
+	path := filepath.Join(root, name)
+	if err := Within(root, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Within returns ErrOutsideRoot unless path is strictly inside root once
+// both are made absolute, cleaned and have their symlinks resolved, so a
+// link under root to a folder elsewhere doesn't count as inside it
+func Within(root, path string) error {
+	realRoot, err := resolve(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := resolve(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return ErrOutsideRoot
+	}
+	return nil
+}
+
+// resolve makes path absolute and resolves the symlinks in the part of it
+// that exists; the rest, such as a file about to be created, is appended
+// as is. A dangling symlink is refused, since writing through it would
+// create its target.
+func resolve(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, err := os.Lstat(dir); err == nil {
+			return "", ErrOutsideRoot
+		}
+		if filepath.Dir(dir) == dir {
+			return abs, nil
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+	}
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanSlug(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // "" means rejected
+	}{
+		{"my-post", "my-post"},
+		{"  my-post  ", "my-post"},
+		{"my-post.md", "my-post"},
+		{"Post_2.v1", "Post_2.v1"},
+		{"..", ""},
+		{".", ""},
+		{"../x", ""},
+		{"../../etc/passwd", ""},
+		{"x/../y", ""},
+		{"/etc/passwd", ""},
+		{"%2e%2e%2f", ""},
+		{"%2e%2e%2fx", ""},
+		{`..\x`, ""},
+		{`x\y`, ""},
+		{`C:\x`, ""},
+		{"x\x00.md", ""},
+		{"\x00", ""},
+		{".hidden", ""},
+		{".history", ""},
+		{".md", ""},
+		{"-flag", ""},
+		{"_library", ""},
+		{"a b", ""},
+		{"", ""},
+		{"a\nb", ""},
+		{"ü", ""},
+		{string(make([]byte, 129)), ""},
+	}
+	for _, tt := range tests {
+		got, err := CleanSlug(tt.in)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("CleanSlug(%q) = %q, %v; want ErrInvalidSlug", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanSlug(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	long := "a"
+	for len(long) < 128 {
+		long += "b"
+	}
+	if _, err := CleanSlug(long); err != nil {
+		t.Errorf("CleanSlug of 128 characters: %v", err)
+	}
+	if _, err := CleanSlug(long + "c"); err == nil {
+		t.Errorf("CleanSlug of 129 characters was accepted")
+	}
+}
+
+func TestSlugPath(t *testing.T) {
+	root := t.TempDir()
+
+	got, err := SlugPath(root, "my-post", ".md")
+	if err != nil || got != filepath.Join(root, "my-post.md") {
+		t.Errorf("SlugPath(my-post, .md) = %q, %v", got, err)
+	}
+	got, err = SlugPath(root, "my-post", "")
+	if err != nil || got != filepath.Join(root, "my-post") {
+		t.Errorf("SlugPath(my-post) = %q, %v", got, err)
+	}
+
+	for _, slug := range []string{"..", "../x", "/etc/passwd", "%2e%2e%2f", `..\x`, "x\x00", ".history", ".x"} {
+		if got, err := SlugPath(root, slug, ".md"); err == nil {
+			t.Errorf("SlugPath(%q) = %q, want an error", slug, got)
+		}
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	root := t.TempDir()
+
+	got, err := SafeJoin(root, "852b3a5b.jpg")
+	if err != nil || got != filepath.Join(root, "852b3a5b.jpg") {
+		t.Errorf("SafeJoin(852b3a5b.jpg) = %q, %v", got, err)
+	}
+
+	for _, name := range []string{"", ".", "..", "../x.jpg", "a/b.jpg", "/x.jpg", `..\x.jpg`, "%2e%2e%2fx.jpg", "x.jpg\x00.png", ".history", ".manifest"} {
+		if got, err := SafeJoin(root, name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("SafeJoin(%q) = %q, %v; want ErrInvalidName", name, got, err)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	root := t.TempDir()
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{filepath.Join(root, "a.md"), true},
+		{filepath.Join(root, "sub", "a.jpg"), true},
+		{filepath.Join(root, "sub", "..", "a.md"), true},
+		{root, false},
+		{root + string(filepath.Separator), false},
+		{filepath.Join(root, ".."), false},
+		{filepath.Join(root, "..", "x"), false},
+		{filepath.Join(root, "sub", "..", "..", "x"), false},
+		{root + "-sibling/a.md", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		err := Within(root, tt.path)
+		if tt.ok && err != nil {
+			t.Errorf("Within(%q) = %v, want inside", tt.path, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Within(%q) = %v, want ErrOutsideRoot", tt.path, err)
+		}
+	}
+}
+
+func TestWithinSymlinks(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "content")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside, filepath.Join(root, "real")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "escape"):         outside,
+		filepath.Join(root, "passwd.md"):      "/etc/passwd",
+		filepath.Join(root, "dangling.md"):    filepath.Join(outside, "new.md"),
+		filepath.Join(root, "inside"):         filepath.Join(root, "real"),
+		filepath.Join(base, "content-link"):   root,
+		filepath.Join(root, "real", "parent"): base,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	outsidePaths := []string{
+		filepath.Join(root, "escape"),
+		filepath.Join(root, "escape", "a.md"),
+		filepath.Join(root, "escape", "new", "a.md"),
+		filepath.Join(root, "passwd.md"),
+		filepath.Join(root, "dangling.md"),
+		filepath.Join(root, "real", "parent", "outside", "a.md"),
+	}
+	for _, path := range outsidePaths {
+		if err := Within(root, path); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Within(%q) = %v, want ErrOutsideRoot", path, err)
+		}
+	}
+	if got, err := SlugPath(root, "escape", ""); err == nil {
+		t.Errorf("SlugPath(escape) = %q, want an error", got)
+	}
+	if got, err := SafeJoin(root, "passwd.md"); err == nil {
+		t.Errorf("SafeJoin(passwd.md) = %q, want an error", got)
+	}
+
+	insidePaths := []string{
+		filepath.Join(root, "inside", "a.md"),
+		filepath.Join(root, "real", "new", "a.md"),
+	}
+	for _, path := range insidePaths {
+		if err := Within(root, path); err != nil {
+			t.Errorf("Within(%q) = %v, want inside", path, err)
+		}
+	}
+
+	// A root that is itself a link, e.g. imagesDir pointing into a site
+	// checkout, still contains its own files
+	linkRoot := filepath.Join(base, "content-link")
+	if err := Within(linkRoot, filepath.Join(linkRoot, "a.md")); err != nil {
+		t.Errorf("Within through a linked root = %v, want inside", err)
+	}
+	if err := Within(linkRoot, filepath.Join(linkRoot, "escape", "a.md")); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Within(linked root, escape) = %v, want ErrOutsideRoot", err)
+	}
+}
+
+func TestLegacySlugPath(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"my old post.md", "_draft.md", "café.md", ".hidden.md"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "folder name.md"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, slug := range []string{"my old post", "_draft", "café"} {
+		got, err := LegacySlugPath(root, slug, ".md")
+		if err != nil || got != filepath.Join(root, slug+".md") {
+			t.Errorf("LegacySlugPath(%q) = %q, %v", slug, got, err)
+		}
+	}
+	for _, slug := range []string{"", ".", "..", "../x", "/etc/passwd", `..\x`, "x\x00", ".hidden", "missing post", "folder name"} {
+		if got, err := LegacySlugPath(root, slug, ".md"); err == nil {
+			t.Errorf("LegacySlugPath(%q) = %q, want an error", slug, got)
+		}
+	}
+}